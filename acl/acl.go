@@ -0,0 +1,138 @@
+package acl
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Capability granted by a rule on a key prefix.
+const (
+	Deny  = "deny"
+	Read  = "read"
+	Write = "write"
+	Admin = "admin"
+)
+
+// levels orders the capabilities; a capability grants every lower one.
+var levels = map[string]int{
+	Deny:  0,
+	Read:  1,
+	Write: 2,
+	Admin: 3,
+}
+
+// Rule grants a capability on every key starting with Prefix.
+type Rule struct {
+	Prefix     string `json:"prefix"`
+	Capability string `json:"capability"`
+}
+
+// Policy is a named set of rules. ClusterAdmin grants access to the /raft, /acl
+// and /debug/pprof endpoints.
+type Policy struct {
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	Rules        []Rule `json:"rules"`
+	ClusterAdmin bool   `json:"cluster_admin"`
+}
+
+// Token links a bearer secret to policies. Only the hash of the secret is stored.
+type Token struct {
+	AccessorID  string   `json:"accessor_id"`
+	SecretHash  string   `json:"-"`
+	Description string   `json:"description"`
+	Policies    []string `json:"policies"`
+}
+
+// Validate checks the policy is well formed before it is replicated.
+func (p Policy) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("policy name is empty")
+	}
+	for _, rule := range p.Rules {
+		if _, ok := levels[rule.Capability]; !ok {
+			return fmt.Errorf("unknown capability %q on prefix %q", rule.Capability, rule.Prefix)
+		}
+	}
+	return nil
+}
+
+// HashSecret returns the form a token secret is stored in.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateID returns a random UUID, used for both accessor IDs and secrets.
+func GenerateID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("error generating id: %s", err)
+	}
+	buf[6] = (buf[6] & 0x0f) | 0x40
+	buf[8] = (buf[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", buf[0:4], buf[4:6], buf[6:8], buf[8:10], buf[10:16]), nil
+}
+
+// Authorizer answers whether a request may act on a key or on the cluster.
+type Authorizer interface {
+	KeyRead(key string) bool
+	KeyWrite(key string) bool
+	KeyAdmin(key string) bool
+	ClusterAdmin() bool
+}
+
+// AllowAll is used when ACLs are disabled and for the master token.
+var AllowAll Authorizer = allowAll{}
+
+type allowAll struct{}
+
+func (allowAll) KeyRead(string) bool  { return true }
+func (allowAll) KeyWrite(string) bool { return true }
+func (allowAll) KeyAdmin(string) bool { return true }
+func (allowAll) ClusterAdmin() bool   { return true }
+
+// policyAuthorizer merges the rules of all policies of a token. The rule with the
+// longest matching prefix decides; on equal prefixes the most restrictive one wins.
+type policyAuthorizer struct {
+	rules        []Rule
+	clusterAdmin bool
+}
+
+// NewAuthorizer builds an authorizer from the policies attached to a token.
+func NewAuthorizer(policies []Policy) Authorizer {
+	a := &policyAuthorizer{}
+	for _, p := range policies {
+		a.rules = append(a.rules, p.Rules...)
+		a.clusterAdmin = a.clusterAdmin || p.ClusterAdmin
+	}
+	return a
+}
+
+func (a *policyAuthorizer) level(key string) int {
+	best, matched := -1, -1
+	for _, rule := range a.rules {
+		if !strings.HasPrefix(key, rule.Prefix) {
+			continue
+		}
+		lvl := levels[rule.Capability]
+		switch {
+		case len(rule.Prefix) > matched:
+			best, matched = lvl, len(rule.Prefix)
+		case len(rule.Prefix) == matched && lvl < best:
+			best = lvl
+		}
+	}
+	if best < 0 {
+		return levels[Deny]
+	}
+	return best
+}
+
+func (a *policyAuthorizer) KeyRead(key string) bool  { return a.level(key) >= levels[Read] }
+func (a *policyAuthorizer) KeyWrite(key string) bool { return a.level(key) >= levels[Write] }
+func (a *policyAuthorizer) KeyAdmin(key string) bool { return a.level(key) >= levels[Admin] }
+func (a *policyAuthorizer) ClusterAdmin() bool       { return a.clusterAdmin }
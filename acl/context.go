@@ -0,0 +1,22 @@
+package acl
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+// contextKey is the echo context key the authorizer of a request is stored under.
+const contextKey = "acl.authorizer"
+
+// WithAuthorizer attaches the authorizer resolved for a request.
+func WithAuthorizer(eCtx echo.Context, authz Authorizer) {
+	eCtx.Set(contextKey, authz)
+}
+
+// FromContext returns the authorizer of a request. Requests that were never
+// authenticated are denied everything.
+func FromContext(eCtx echo.Context) Authorizer {
+	if authz, ok := eCtx.Get(contextKey).(Authorizer); ok {
+		return authz
+	}
+	return NewAuthorizer(nil)
+}
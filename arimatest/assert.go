@@ -0,0 +1,108 @@
+package arimatest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rohankmr414/arima"
+)
+
+// Set sets key to value through the leader, failing the test on error.
+func (c *Cluster) Set(key, value string) {
+	c.t.Helper()
+	leader := c.WaitForLeader()
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+	err := arima.ErrNotStarted
+	if node := leader.Current(); node != nil {
+		err = node.Set(ctx, []byte(key), []byte(value))
+	}
+	if err != nil {
+		c.t.Fatalf("error setting %s on %s: %s", key, leader.ID, err)
+	}
+}
+
+// Delete deletes key through the leader, failing the test on error.
+func (c *Cluster) Delete(key string) {
+	c.t.Helper()
+	leader := c.WaitForLeader()
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+	err := arima.ErrNotStarted
+	if node := leader.Current(); node != nil {
+		err = node.Delete(ctx, []byte(key))
+	}
+	if err != nil {
+		c.t.Fatalf("error deleting %s on %s: %s", key, leader.ID, err)
+	}
+}
+
+// WaitForValue waits until key is set to want on every one of nodes, the
+// running nodes when none are given.
+func (c *Cluster) WaitForValue(key, want string, nodes ...*Node) {
+	c.t.Helper()
+	c.waitForFSM(key, fmt.Sprintf("%s=%q", key, want), nodes, func(value string, ok bool) bool {
+		return ok && value == want
+	})
+}
+
+// WaitForMissing waits until key is not set on any of nodes, the running nodes
+// when none are given.
+func (c *Cluster) WaitForMissing(key string, nodes ...*Node) {
+	c.t.Helper()
+	c.waitForFSM(key, fmt.Sprintf("%s to be missing", key), nodes, func(_ string, ok bool) bool {
+		return !ok
+	})
+}
+
+// RequireValue fails the test unless key is set to want on node.
+func (c *Cluster) RequireValue(node *Node, key, want string) {
+	c.t.Helper()
+	if value, ok := node.Value(key); !ok || value != want {
+		c.t.Fatalf("%s: %s is %s, want %q", node.ID, key, describe(value, ok), want)
+	}
+}
+
+// RequireMissing fails the test if key is set on node.
+func (c *Cluster) RequireMissing(node *Node, key string) {
+	c.t.Helper()
+	if value, ok := node.Value(key); ok {
+		c.t.Fatalf("%s: %s is %q, want it missing", node.ID, key, value)
+	}
+}
+
+// waitForFSM waits until the value of key satisfies cond on every one of nodes,
+// reporting the value on each node when it times out.
+func (c *Cluster) waitForFSM(key, what string, nodes []*Node, cond func(value string, ok bool) bool) {
+	c.t.Helper()
+	if len(nodes) == 0 {
+		nodes = c.running()
+	}
+	deadline := time.Now().Add(c.Timeout)
+	for {
+		holds := true
+		var values []string
+		for _, n := range nodes {
+			value, ok := n.Value(key)
+			holds = holds && cond(value, ok)
+			values = append(values, fmt.Sprintf("%s: %s", n.ID, describe(value, ok)))
+		}
+		if holds {
+			return
+		}
+		if time.Now().After(deadline) {
+			c.t.Fatalf("timed out after %s waiting for %s, got %s", c.Timeout, what, strings.Join(values, ", "))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// describe formats a value read from a node.
+func describe(value string, ok bool) string {
+	if !ok {
+		return "missing"
+	}
+	return fmt.Sprintf("%q", value)
+}
@@ -0,0 +1,374 @@
+// Package arimatest runs clusters of arima nodes inside a test process. The
+// nodes keep their data in memory and talk over raft.InmemTransport, so the
+// network between them can be cut and restored, and nodes can be killed and
+// restarted with the data they had.
+package arimatest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/rohankmr414/arima"
+)
+
+// DefaultTimeout bounds how long the helpers wait for the cluster.
+const DefaultTimeout = 10 * time.Second
+
+// Node is a node of a test cluster. The arima node it runs is replaced when it
+// is restarted.
+type Node struct {
+	ID string
+
+	opts      arima.Options
+	transport *raft.InmemTransport
+	// group is the side of a partition the node is on, guarded by the mutex
+	// of the cluster.
+	group int
+
+	mu   sync.Mutex
+	node *arima.Node
+}
+
+// Current returns the arima node running now, nil while the node is killed. A
+// node killed after it is returned fails with arima.ErrNotStarted.
+func (n *Node) Current() *arima.Node {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.node
+}
+
+func (n *Node) setCurrent(node *arima.Node) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.node = node
+}
+
+// Address returns the raft address of the node.
+func (n *Node) Address() string {
+	return string(n.transport.LocalAddr())
+}
+
+// Alive reports whether the node is running.
+func (n *Node) Alive() bool {
+	return n.Current() != nil
+}
+
+// Value returns the value of key in the FSM of the node, and whether it is set.
+func (n *Node) Value(key string) (string, bool) {
+	node := n.Current()
+	if node == nil {
+		return "", false
+	}
+	value, err := node.Get([]byte(key))
+	if err != nil {
+		return "", false
+	}
+	return string(value), true
+}
+
+// Cluster is a cluster of in-process nodes, shut down when the test finishes.
+type Cluster struct {
+	t testing.TB
+	// Timeout bounds how long the helpers wait, DefaultTimeout by default.
+	Timeout time.Duration
+
+	mu    sync.Mutex
+	nodes []*Node
+}
+
+// NewCluster starts a cluster of size voters and waits for it to elect a
+// leader. configure, when not nil, adjusts the options of every node before it
+// is started; it must leave the node ID, transport and bootstrap servers alone.
+func NewCluster(t testing.TB, size int, configure func(opts *arima.Options)) *Cluster {
+	t.Helper()
+	c := &Cluster{t: t, Timeout: DefaultTimeout}
+	t.Cleanup(c.Close)
+
+	var servers []raft.Server
+	for i := 0; i < size; i++ {
+		_, transport := raft.NewInmemTransport("")
+		opts := arima.Options{
+			NodeID:       fmt.Sprintf("node-%d", i),
+			Transport:    transport,
+			Raft:         raftConfig(),
+			Storage:      arima.StorageOptions{InMemory: true},
+			MemoryStores: arima.NewMemoryStores(),
+			LogOutput:    ioutil.Discard,
+		}
+		servers = append(servers, raft.Server{
+			Suffrage: raft.Voter,
+			ID:       raft.ServerID(opts.NodeID),
+			Address:  transport.LocalAddr(),
+		})
+		c.nodes = append(c.nodes, &Node{ID: opts.NodeID, opts: opts, transport: transport})
+	}
+	for _, n := range c.nodes {
+		n.opts.Bootstrap = servers
+		if configure != nil {
+			configure(&n.opts)
+		}
+	}
+	for _, n := range c.nodes {
+		c.start(n)
+	}
+	c.rewire()
+	c.WaitForLeader()
+	return c
+}
+
+// raftConfig returns a raft configuration with the short timeouts of an
+// in-memory network.
+func raftConfig() *raft.Config {
+	conf := raft.DefaultConfig()
+	conf.HeartbeatTimeout = 50 * time.Millisecond
+	conf.ElectionTimeout = 50 * time.Millisecond
+	conf.LeaderLeaseTimeout = 50 * time.Millisecond
+	conf.CommitTimeout = 5 * time.Millisecond
+	conf.LogOutput = ioutil.Discard
+	return conf
+}
+
+func (c *Cluster) start(n *Node) {
+	c.t.Helper()
+	node, err := arima.New(n.opts)
+	if err != nil {
+		c.t.Fatalf("error creating %s: %s", n.ID, err)
+	}
+	if err := node.Start(context.Background()); err != nil {
+		c.t.Fatalf("error starting %s: %s", n.ID, err)
+	}
+	n.setCurrent(node)
+}
+
+// rewire connects every pair of running nodes on the same side of a partition,
+// and disconnects every other pair.
+func (c *Cluster) rewire() {
+	for _, a := range c.nodes {
+		for _, b := range c.nodes {
+			if a == b {
+				continue
+			}
+			if a.Alive() && b.Alive() && a.group == b.group {
+				a.transport.Connect(b.transport.LocalAddr(), b.transport)
+			} else {
+				a.transport.Disconnect(b.transport.LocalAddr())
+			}
+		}
+	}
+}
+
+// Nodes returns the nodes of the cluster, killed ones included.
+func (c *Cluster) Nodes() []*Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*Node(nil), c.nodes...)
+}
+
+// Node returns the node with the given ID.
+func (c *Cluster) Node(id string) *Node {
+	c.t.Helper()
+	for _, n := range c.Nodes() {
+		if n.ID == id {
+			return n
+		}
+	}
+	c.t.Fatalf("no node %s in the cluster", id)
+	return nil
+}
+
+// Leader returns a running node that believes it leads, nil when there is none.
+// A node cut off from the majority may keep believing so for a while; see
+// WaitForLeader.
+func (c *Cluster) Leader() *Node {
+	for _, n := range c.Nodes() {
+		if n.isLeader() {
+			return n
+		}
+	}
+	return nil
+}
+
+// isLeader reports whether the node is running and believes it leads.
+func (n *Node) isLeader() bool {
+	node := n.Current()
+	return node != nil && node.IsLeader()
+}
+
+// WaitForLeader waits until one of nodes leads and every other one of them
+// follows it, and returns it. Without nodes, it waits for the running nodes on
+// the side of a partition holding a quorum.
+func (c *Cluster) WaitForLeader(nodes ...*Node) *Node {
+	c.t.Helper()
+	if len(nodes) == 0 {
+		nodes = c.quorum()
+	}
+	var leader *Node
+	c.waitFor("a leader", c.states, func() bool {
+		leader = nil
+		for _, n := range nodes {
+			if n.isLeader() {
+				leader = n
+			}
+		}
+		if leader == nil {
+			return false
+		}
+		for _, n := range nodes {
+			node := n.Current()
+			if node == nil {
+				continue
+			}
+			if address, _ := node.Leader(); address != leader.Address() {
+				return false
+			}
+		}
+		return true
+	})
+	return leader
+}
+
+// Partition cuts the given nodes off from the rest of the cluster. Nodes on the
+// same side can still reach each other.
+func (c *Cluster) Partition(nodes ...*Node) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	group := 0
+	for _, n := range c.nodes {
+		if n.group > group {
+			group = n.group
+		}
+	}
+	for _, n := range nodes {
+		n.group = group + 1
+	}
+	c.rewire()
+}
+
+// Heal reconnects every node.
+func (c *Cluster) Heal() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, n := range c.nodes {
+		n.group = 0
+	}
+	c.rewire()
+}
+
+// Kill stops a node the way a crash would: it is cut off and stopped without
+// handing leadership over, and its in-memory stores are kept for Restart.
+func (c *Cluster) Kill(n *Node) {
+	c.t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node := n.Current()
+	if node == nil {
+		return
+	}
+	n.setCurrent(nil)
+	c.rewire()
+
+	if err := node.Stop(); err != nil {
+		c.t.Fatalf("error stopping %s: %s", n.ID, err)
+	}
+}
+
+// Restart starts a killed node again with the data it had. Its FSM is rebuilt
+// from its snapshot and raft log.
+func (c *Cluster) Restart(n *Node) {
+	c.t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n.Alive() {
+		c.t.Fatalf("%s is running", n.ID)
+	}
+	c.start(n)
+	c.rewire()
+}
+
+// Close shuts every running node down.
+func (c *Cluster) Close() {
+	for _, n := range c.running() {
+		node := n.Current()
+		if node == nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+		if err := node.Shutdown(ctx); err != nil {
+			c.t.Errorf("error shutting down %s: %s", n.ID, err)
+		}
+		cancel()
+	}
+}
+
+// quorum returns the running nodes on the side of a partition that holds a
+// quorum, or all running nodes when no side does.
+func (c *Cluster) quorum() []*Node {
+	c.mu.Lock()
+	nodes := append([]*Node(nil), c.nodes...)
+	groups := map[int][]*Node{}
+	for _, n := range nodes {
+		groups[n.group] = append(groups[n.group], n)
+	}
+	c.mu.Unlock()
+	for _, group := range groups {
+		if len(group) > len(nodes)/2 {
+			var running []*Node
+			for _, n := range group {
+				if n.Alive() {
+					running = append(running, n)
+				}
+			}
+			return running
+		}
+	}
+	return c.running()
+}
+
+// running returns the nodes that are not killed.
+func (c *Cluster) running() []*Node {
+	var nodes []*Node
+	for _, n := range c.Nodes() {
+		if n.Alive() {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// waitFor polls cond until it holds, failing the test with the report once
+// Timeout passes.
+func (c *Cluster) waitFor(what string, report func() string, cond func() bool) {
+	c.t.Helper()
+	deadline := time.Now().Add(c.Timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			c.t.Fatalf("timed out after %s waiting for %s, got %s", c.Timeout, what, report())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// states describes the raft state of every node and the leader it follows.
+func (c *Cluster) states() string {
+	var states []string
+	for _, n := range c.Nodes() {
+		node := n.Current()
+		var r *raft.Raft
+		if node != nil {
+			r = node.Raft()
+		}
+		if r == nil {
+			states = append(states, fmt.Sprintf("%s: killed", n.ID))
+			continue
+		}
+		_, leader := node.Leader()
+		states = append(states, fmt.Sprintf("%s: %s, leader %q", n.ID, r.State(), leader))
+	}
+	return strings.Join(states, ", ")
+}
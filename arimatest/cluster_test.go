@@ -0,0 +1,108 @@
+package arimatest
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestLeaderElection(t *testing.T) {
+	c := NewCluster(t, 3, nil)
+	leader := c.WaitForLeader()
+	c.Set("key", "value")
+	c.WaitForValue("key", "value")
+
+	c.Kill(leader)
+	next := c.WaitForLeader()
+	if next == leader {
+		t.Fatalf("%s still leads after it was killed", leader.ID)
+	}
+	c.Set("key", "new")
+	c.WaitForValue("key", "new")
+	c.RequireMissing(leader, "key")
+}
+
+func TestKillRestart(t *testing.T) {
+	c := NewCluster(t, 3, nil)
+	c.Set("key", "value")
+	c.Set("gone", "value")
+	c.WaitForValue("key", "value")
+
+	leader := c.WaitForLeader()
+	var follower *Node
+	for _, n := range c.Nodes() {
+		if n != leader {
+			follower = n
+			break
+		}
+	}
+	c.Kill(follower)
+	if follower.Alive() {
+		t.Fatalf("%s is alive after it was killed", follower.ID)
+	}
+	c.Set("key", "new")
+	c.Delete("gone")
+	c.WaitForValue("key", "new")
+
+	c.Restart(follower)
+	c.WaitForValue("key", "new", follower)
+	c.WaitForMissing("gone", follower)
+
+	leader = c.WaitForLeader()
+	c.Kill(leader)
+	c.Restart(leader)
+	c.WaitForLeader()
+	c.WaitForValue("key", "new", leader)
+}
+
+func TestPartitionHeal(t *testing.T) {
+	c := NewCluster(t, 3, nil)
+	c.Set("key", "value")
+	c.WaitForValue("key", "value")
+
+	old := c.WaitForLeader()
+	c.Partition(old)
+	leader := c.WaitForLeader()
+	if leader == old {
+		t.Fatalf("%s leads from the minority side of a partition", old.ID)
+	}
+	c.Set("key", "new")
+	c.WaitForValue("key", "new", leader)
+	c.RequireValue(old, "key", "value")
+
+	c.Heal()
+	c.WaitForLeader()
+	c.WaitForValue("key", "new")
+}
+
+func TestKillWhileWaiting(t *testing.T) {
+	c := NewCluster(t, 3, nil)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			for _, n := range c.Nodes() {
+				n.Alive()
+				n.Value("key")
+			}
+			c.Leader()
+			c.states()
+		}
+	}()
+
+	for i := 0; i < 3; i++ {
+		leader := c.WaitForLeader()
+		c.Kill(leader)
+		c.Set("key", "value")
+		c.Restart(leader)
+	}
+	close(stop)
+	wg.Wait()
+	c.WaitForValue("key", "value")
+}
@@ -0,0 +1,265 @@
+package autopilot
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/rohankmr414/arima/cluster"
+)
+
+// Config tunes when servers are considered healthy and what autopilot does about
+// unhealthy ones.
+type Config struct {
+	// Interval is how often the leader checks the health of the servers.
+	Interval time.Duration
+	// LastContactThreshold is how long a server may go without hearing from
+	// the leader and still be healthy.
+	LastContactThreshold time.Duration
+	// MaxLag is how many log entries a server may trail the leader and still
+	// be healthy.
+	MaxLag uint64
+	// DeadServerGrace is how long a server must have been unhealthy before it
+	// is removed from the cluster. Zero disables removal.
+	DeadServerGrace time.Duration
+	// MinVoters is the number of voters autopilot maintains by promoting
+	// healthy non-voters when voters are lost. Zero disables promotion.
+	MinVoters int
+	// StabilizationTime is how long a non-voter must have been healthy before
+	// it is promoted.
+	StabilizationTime time.Duration
+}
+
+// ServerHealth is the health of a server as tracked by the leader.
+type ServerHealth struct {
+	NodeID      string `json:"node_id"`
+	RaftAddress string `json:"raft_address"`
+	Suffrage    string `json:"suffrage"`
+	Leader      bool   `json:"leader"`
+	Healthy     bool   `json:"healthy"`
+	// StableSince is when the server last changed between healthy and unhealthy.
+	StableSince time.Time `json:"stable_since"`
+	// Reason explains why the server is unhealthy.
+	Reason      string  `json:"reason,omitempty"`
+	LastContact string  `json:"last_contact"`
+	Lag         *uint64 `json:"lag"`
+}
+
+// Autopilot tracks the health of the servers on the leader, removes servers that
+// stay unhealthy and promotes non-voters to keep enough voters.
+type Autopilot struct {
+	raft    *raft.Raft
+	monitor *cluster.Monitor
+	conf    Config
+
+	mu     sync.RWMutex
+	health map[string]*ServerHealth
+}
+
+// New returns an autopilot, which does nothing until Run is called.
+func New(r *raft.Raft, monitor *cluster.Monitor, conf Config) *Autopilot {
+	return &Autopilot{
+		raft:    r,
+		monitor: monitor,
+		conf:    conf,
+		health:  map[string]*ServerHealth{},
+	}
+}
+
+// Run checks the servers every interval until raft shuts down.
+func (a *Autopilot) Run() {
+	ticker := time.NewTicker(a.conf.Interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		switch a.raft.State() {
+		case raft.Shutdown:
+			return
+		case raft.Leader:
+			if err := a.check(); err != nil {
+				log.Printf("autopilot: %s", err)
+			}
+		default:
+			// Health is only known on the leader, and is tracked afresh
+			// when this node leads again.
+			a.mu.Lock()
+			a.health = map[string]*ServerHealth{}
+			a.mu.Unlock()
+		}
+	}
+}
+
+// Health returns the health of every server ordered by node ID. It is empty
+// unless this node is the leader.
+func (a *Autopilot) Health() []ServerHealth {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	health := make([]ServerHealth, 0, len(a.health))
+	for _, server := range a.health {
+		health = append(health, *server)
+	}
+	sort.Slice(health, func(i, j int) bool {
+		return health[i].NodeID < health[j].NodeID
+	})
+	return health
+}
+
+func (a *Autopilot) check() error {
+	// Changes are made against the configuration read before the members, so
+	// one made concurrently fails them instead of being acted on blindly.
+	configFuture := a.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return fmt.Errorf("failed to get raft configuration: %s", err)
+	}
+	prevIndex := configFuture.Index()
+	members, err := a.monitor.Members()
+	if err != nil {
+		return err
+	}
+	health := a.update(members)
+
+	if a.conf.DeadServerGrace > 0 {
+		removed, err := a.removeDeadServer(health, prevIndex)
+		if err != nil || removed {
+			return err
+		}
+	}
+	if a.conf.MinVoters > 0 {
+		return a.promoteNonvoter(health, prevIndex)
+	}
+	return nil
+}
+
+// update records the health of the members and returns a copy of it.
+func (a *Autopilot) update(members []cluster.Member) []ServerHealth {
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	current := make(map[string]*ServerHealth, len(members))
+	for _, member := range members {
+		reason := a.unhealthyReason(member)
+		server := &ServerHealth{
+			NodeID:      member.NodeID,
+			RaftAddress: member.RaftAddress,
+			Suffrage:    member.Suffrage,
+			Leader:      member.Leader,
+			Healthy:     reason == "",
+			StableSince: now,
+			Reason:      reason,
+			LastContact: member.LastContact,
+			Lag:         member.Lag,
+		}
+		if previous, ok := a.health[member.NodeID]; ok && previous.Healthy == server.Healthy {
+			server.StableSince = previous.StableSince
+		}
+		current[member.NodeID] = server
+	}
+	a.health = current
+
+	health := make([]ServerHealth, 0, len(current))
+	for _, server := range current {
+		health = append(health, *server)
+	}
+	sort.Slice(health, func(i, j int) bool {
+		return health[i].NodeID < health[j].NodeID
+	})
+	return health
+}
+
+// unhealthyReason decides the health of a member from the contact raft has with
+// it. The lag the member reports over HTTP only refines it: a member that does
+// not answer the poll is not unhealthy for it.
+func (a *Autopilot) unhealthyReason(member cluster.Member) string {
+	if member.Leader || member.Reachable == nil {
+		return ""
+	}
+	if !*member.Reachable {
+		lastContact, err := time.ParseDuration(member.LastContact)
+		if err != nil {
+			return fmt.Sprintf("no contact with the leader (%s)", member.LastContact)
+		}
+		if lastContact > a.conf.LastContactThreshold {
+			return fmt.Sprintf("last contact %s ago", member.LastContact)
+		}
+	}
+	if member.Lag != nil && *member.Lag > a.conf.MaxLag {
+		return fmt.Sprintf("%d entries behind the leader", *member.Lag)
+	}
+	return ""
+}
+
+// removeDeadServer removes one server that has been unhealthy for longer than the
+// grace period, and reports whether it did. A voter is only removed if the healthy
+// voters left are a quorum of the smaller cluster.
+func (a *Autopilot) removeDeadServer(health []ServerHealth, prevIndex uint64) (bool, error) {
+	voters, healthyVoters := 0, 0
+	for _, server := range health {
+		if server.Suffrage == raft.Voter.String() {
+			voters++
+			if server.Healthy {
+				healthyVoters++
+			}
+		}
+	}
+
+	for _, server := range health {
+		if server.Healthy || server.Leader || time.Since(server.StableSince) < a.conf.DeadServerGrace {
+			continue
+		}
+		if server.Suffrage == raft.Voter.String() && healthyVoters < (voters-1)/2+1 {
+			log.Printf("autopilot: not removing dead server %s, the healthy voters left would not be a quorum", server.NodeID)
+			continue
+		}
+
+		log.Printf("autopilot: removing dead server %s: %s", server.NodeID, server.Reason)
+		if err := a.raft.RemoveServer(raft.ServerID(server.NodeID), prevIndex, 0).Error(); err != nil {
+			return false, fmt.Errorf("error removing dead server %s: %s", server.NodeID, err)
+		}
+		if err := cluster.ForgetHTTPAddress(a.raft, server.NodeID); err != nil {
+			return true, fmt.Errorf("error forgetting http address of %s: %s", server.NodeID, err)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// promoteNonvoter promotes the least lagging non-voter that has been healthy for
+// the stabilization time while there are fewer voters than the minimum.
+func (a *Autopilot) promoteNonvoter(health []ServerHealth, prevIndex uint64) error {
+	voters := 0
+	var candidate *ServerHealth
+	for i, server := range health {
+		if server.Suffrage == raft.Voter.String() {
+			voters++
+			continue
+		}
+		if !server.Healthy || time.Since(server.StableSince) < a.conf.StabilizationTime {
+			continue
+		}
+		if candidate == nil || lag(server) < lag(*candidate) {
+			candidate = &health[i]
+		}
+	}
+	if voters >= a.conf.MinVoters || candidate == nil {
+		return nil
+	}
+
+	log.Printf("autopilot: promoting %s, the cluster has %d of %d voters", candidate.NodeID, voters, a.conf.MinVoters)
+	future := a.raft.AddVoter(raft.ServerID(candidate.NodeID), raft.ServerAddress(candidate.RaftAddress), prevIndex, 0)
+	if err := future.Error(); err != nil {
+		return fmt.Errorf("error promoting %s: %s", candidate.NodeID, err)
+	}
+	return nil
+}
+
+func lag(server ServerHealth) uint64 {
+	if server.Lag == nil {
+		return ^uint64(0)
+	}
+	return *server.Lag
+}
@@ -0,0 +1,81 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Magic starts every backup file.
+var Magic = []byte("ARIMABAK")
+
+const (
+	version = 1
+
+	// maxHeaderSize bounds the JSON header read from untrusted files.
+	maxHeaderSize = 1 << 20
+)
+
+// Header describes the FSM dump that follows it in a backup file.
+type Header struct {
+	// Index and Term are those of the last raft command included in the dump.
+	Index     uint64    `json:"index"`
+	Term      uint64    `json:"term"`
+	CreatedAt time.Time `json:"created_at"`
+	// Envelope is set on dumps wrapped in the snapshot envelope, which backups
+	// taken before it was introduced lack.
+	Envelope bool `json:"envelope"`
+}
+
+// A backup file is
+//
+//	magic | version (1) | header length (4, big endian) | JSON header | dump
+//
+// where the dump is in the format the FSM restores snapshots from.
+
+// WriteHeader writes the magic and the header, after which the dump is written.
+func WriteHeader(w io.Writer, header Header) error {
+	data, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	prefix := append(append([]byte{}, Magic...), version, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(prefix[len(Magic)+1:], uint32(len(data)))
+	if _, err := w.Write(prefix); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// ReadHeader reads the magic and the header, leaving r at the start of the dump.
+func ReadHeader(r io.Reader) (*Header, error) {
+	prefix := make([]byte, len(Magic)+1+4)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, fmt.Errorf("error reading backup header: %s", err)
+	}
+	if !bytes.Equal(prefix[:len(Magic)], Magic) {
+		return nil, errors.New("not an arima backup")
+	}
+	if prefix[len(Magic)] != version {
+		return nil, fmt.Errorf("unsupported backup version %d", prefix[len(Magic)])
+	}
+
+	size := binary.BigEndian.Uint32(prefix[len(Magic)+1:])
+	if size > maxHeaderSize {
+		return nil, fmt.Errorf("backup header of %d bytes is too large", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("error reading backup header: %s", err)
+	}
+	var header Header
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("error decoding backup header: %s", err)
+	}
+	return &header, nil
+}
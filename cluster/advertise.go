@@ -0,0 +1,79 @@
+package cluster
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/rohankmr414/arima/fsm"
+	"github.com/rohankmr414/arima/utils"
+)
+
+// advertiseInterval is how often the leader checks its own HTTP address is recorded.
+const advertiseInterval = time.Second
+
+// AdvertiseHTTPAddress records the HTTP address of this node whenever it leads
+// and the recorded address is missing or stale. Followers have theirs recorded
+// when they join. Nothing is recorded while the node is alone, since every node
+// starts out as the leader of its own cluster and writes made then would diverge
+// from the log of the cluster it joins. It returns once raft has shut down.
+func AdvertiseHTTPAddress(r *raft.Raft, arimaFsm *fsm.ArimaFSM, nodeID, address string) {
+	ticker := time.NewTicker(advertiseInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if r.State() == raft.Shutdown {
+			return
+		}
+		if r.State() != raft.Leader {
+			continue
+		}
+		future := r.GetConfiguration()
+		if err := future.Error(); err != nil || len(future.Configuration().Servers) < 2 {
+			continue
+		}
+		if recorded, err := arimaFsm.MemberHTTPAddress(nodeID); err == nil && recorded == address {
+			continue
+		}
+
+		if err := RecordHTTPAddress(r, nodeID, address); err != nil {
+			log.Printf("error recording http address: %s", err)
+		}
+	}
+}
+
+// apply replicates the payload and returns the error reported by the FSM.
+func apply(r *raft.Raft, payload fsm.CommandPayload) error {
+	data, err := utils.EncodeMsgPack(payload)
+	if err != nil {
+		return err
+	}
+
+	applyFuture := r.Apply(data.Bytes(), 500*time.Millisecond)
+	if err := applyFuture.Error(); err != nil {
+		return err
+	}
+
+	resp, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	if !ok {
+		return fmt.Errorf("error response is not match apply response")
+	}
+	return resp.Error
+}
+
+// RecordHTTPAddress records the HTTP address of a node, so the leader can poll it.
+func RecordHTTPAddress(r *raft.Raft, nodeID, address string) error {
+	return apply(r, fsm.CommandPayload{
+		Operation: "member_set",
+		Key:       []byte(nodeID),
+		Value:     []byte(address),
+	})
+}
+
+// ForgetHTTPAddress removes the HTTP address recorded for a node that left.
+func ForgetHTTPAddress(r *raft.Raft, nodeID string) error {
+	return apply(r, fsm.CommandPayload{
+		Operation: "member_delete",
+		Key:       []byte(nodeID),
+	})
+}
@@ -0,0 +1,76 @@
+package cluster
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/raft"
+	"github.com/rohankmr414/arima/fsm"
+	"github.com/rohankmr414/arima/store"
+)
+
+// AddServer adds a server to the configuration, or leaves it as it is when it is
+// already a member, which it reports. It must be run on the leader.
+func AddServer(r *raft.Raft, nodeID, raftAddr string, nonVoter bool) (bool, error) {
+	configFuture := r.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return false, fmt.Errorf("failed to get raft configuration: %s", err)
+	}
+
+	// Every membership change is made against the configuration it was checked
+	// against, so concurrent changes fail instead of overwriting each other.
+	prevIndex := configFuture.Index()
+	for _, server := range configFuture.Configuration().Servers {
+		if server.ID == raft.ServerID(nodeID) && server.Address == raft.ServerAddress(raftAddr) {
+			// Suffrage is left as it is; promote and demote change it.
+			return true, nil
+		}
+		if server.ID == raft.ServerID(nodeID) || server.Address == raft.ServerAddress(raftAddr) {
+			if server.Address == r.Leader() {
+				return false, fmt.Errorf("node %s at %s conflicts with the leader", nodeID, raftAddr)
+			}
+			// A node that rejoins under a new address, or a new node reusing
+			// the address of an old one, replaces the stale entry.
+			future := r.RemoveServer(server.ID, prevIndex, 0)
+			if err := future.Error(); err != nil {
+				return false, fmt.Errorf("error removing stale member %s at %s: %s", server.ID, server.Address, err)
+			}
+			prevIndex = future.Index()
+		}
+	}
+
+	if nonVoter {
+		if err := r.AddNonvoter(raft.ServerID(nodeID), raft.ServerAddress(raftAddr), prevIndex, 0).Error(); err != nil {
+			return false, fmt.Errorf("error add non-voter: %s", err)
+		}
+		return false, nil
+	}
+	if err := r.AddVoter(raft.ServerID(nodeID), raft.ServerAddress(raftAddr), prevIndex, 0).Error(); err != nil {
+		return false, fmt.Errorf("error add voter: %s", err)
+	}
+	return false, nil
+}
+
+// RemoveServer removes a server from the configuration checked at prevIndex,
+// together with the HTTP address recorded for it. It must be run on the leader.
+// The address is forgotten first, since a leader that removed itself can no
+// longer apply anything, and recorded again if the removal fails.
+func RemoveServer(r *raft.Raft, arimaFsm *fsm.ArimaFSM, nodeID string, prevIndex uint64) error {
+	address, err := arimaFsm.MemberHTTPAddress(nodeID)
+	if err != nil && err != store.ErrNotFound {
+		return fmt.Errorf("error reading http address: %s", err)
+	}
+	if err := ForgetHTTPAddress(r, nodeID); err != nil {
+		return fmt.Errorf("error forgetting http address: %s", err)
+	}
+
+	if err := r.RemoveServer(raft.ServerID(nodeID), prevIndex, 0).Error(); err != nil {
+		if address != "" {
+			if rerr := RecordHTTPAddress(r, nodeID, address); rerr != nil {
+				log.Printf("error recording http address of %s again: %s", nodeID, rerr)
+			}
+		}
+		return fmt.Errorf("error removing existing node %s: %s", nodeID, err)
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package cluster
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/rohankmr414/arima/fsm"
+)
+
+// pollTimeout bounds how long the leader waits for a member to report its state.
+const pollTimeout = time.Second
+
+// Member is a server of the raft configuration together with its replication
+// state as seen from the leader.
+type Member struct {
+	NodeID      string `json:"node_id"`
+	RaftAddress string `json:"raft_address"`
+	HTTPAddress string `json:"http_address"`
+	Suffrage    string `json:"suffrage"`
+	Leader      bool   `json:"leader"`
+	// Reachable reports whether the heartbeats of the leader reach the member,
+	// as raft sees them. It is nil on other nodes.
+	Reachable *bool `json:"reachable"`
+	// LastContact is how long ago the member last heard from the leader. For
+	// unreachable members it is the last contact raft knows of; reachable ones
+	// report it themselves over HTTP, best effort.
+	LastContact string `json:"last_contact"`
+	// LastLogIndex is the last log index the member stored, and Lag how many
+	// entries it trails the leader. The member reports them over HTTP, best
+	// effort: both are nil when the poll fails.
+	LastLogIndex *uint64 `json:"last_log_index"`
+	Lag          *uint64 `json:"lag"`
+	// Error explains why the member could not be polled over HTTP. It says
+	// nothing about its health, which Reachable does.
+	Error string `json:"error,omitempty"`
+}
+
+// Monitor follows the replication state of the cluster members. Whether a
+// member is reachable comes from the heartbeats of raft on the leader, which
+// remembers when they started failing. Members also report their own state over
+// their HTTP API, which refines the lag when they answer.
+type Monitor struct {
+	raft   *raft.Raft
+	fsm    *fsm.ArimaFSM
+	client *http.Client
+
+	mu      sync.Mutex
+	failing map[raft.ServerID]time.Time
+
+	observer     *raft.Observer
+	observations chan raft.Observation
+	closeOnce    sync.Once
+}
+
+// NewMonitor returns a monitor polling members with tlsConfig when it is set.
+func NewMonitor(r *raft.Raft, arimaFsm *fsm.ArimaFSM, tlsConfig *tls.Config) *Monitor {
+	m := &Monitor{
+		raft:    r,
+		fsm:     arimaFsm,
+		client:  &http.Client{Timeout: pollTimeout},
+		failing: map[raft.ServerID]time.Time{},
+	}
+	if tlsConfig != nil {
+		m.client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+
+	// The observer blocks, so a dropped resumed heartbeat cannot leave a member
+	// marked as failing.
+	m.observations = make(chan raft.Observation, 16)
+	m.observer = raft.NewObserver(m.observations, true, func(o *raft.Observation) bool {
+		switch o.Data.(type) {
+		case raft.FailedHeartbeatObservation, raft.ResumedHeartbeatObservation, raft.PeerObservation, raft.RaftState:
+			return true
+		}
+		return false
+	})
+	r.RegisterObserver(m.observer)
+	go m.observe(m.observations)
+	return m
+}
+
+// Close stops following the heartbeats of raft.
+func (m *Monitor) Close() {
+	m.closeOnce.Do(func() {
+		// Observations are still drained until raft no longer sends any.
+		m.raft.DeregisterObserver(m.observer)
+		close(m.observations)
+	})
+}
+
+func (m *Monitor) observe(observations <-chan raft.Observation) {
+	for o := range observations {
+		m.mu.Lock()
+		switch data := o.Data.(type) {
+		case raft.FailedHeartbeatObservation:
+			if _, ok := m.failing[data.PeerID]; !ok {
+				m.failing[data.PeerID] = data.LastContact
+			}
+		case raft.ResumedHeartbeatObservation:
+			delete(m.failing, data.PeerID)
+		case raft.PeerObservation:
+			if data.Removed {
+				delete(m.failing, data.Peer.ID)
+			}
+		case raft.RaftState:
+			// Failures seen in an earlier term say nothing about the
+			// heartbeats of a new one.
+			if data == raft.Leader {
+				m.failing = map[raft.ServerID]time.Time{}
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
+// Members returns every server of the configuration. Replication state is only
+// reported by the leader; other nodes only list the configuration.
+func (m *Monitor) Members() ([]Member, error) {
+	configFuture := m.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return nil, fmt.Errorf("failed to get raft configuration: %s", err)
+	}
+	addresses, err := m.fsm.MemberHTTPAddresses()
+	if err != nil {
+		return nil, err
+	}
+
+	isLeader := m.raft.State() == raft.Leader
+	leader := m.raft.Leader()
+	stats := m.raft.Stats()
+	leaderIndex, _ := strconv.ParseUint(stats["last_log_index"], 10, 64)
+
+	servers := configFuture.Configuration().Servers
+	members := make([]Member, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		members[i] = Member{
+			NodeID:      string(server.ID),
+			RaftAddress: string(server.Address),
+			HTTPAddress: addresses[string(server.ID)],
+			Suffrage:    server.Suffrage.String(),
+			Leader:      server.Address == leader,
+		}
+		if !isLeader {
+			continue
+		}
+		if members[i].Leader {
+			reachable := true
+			members[i].Reachable = &reachable
+			members[i].LastContact = stats["last_contact"]
+			members[i].LastLogIndex = &leaderIndex
+			members[i].Lag = new(uint64)
+			continue
+		}
+
+		wg.Add(1)
+		go func(member *Member) {
+			defer wg.Done()
+			m.poll(member, leaderIndex)
+		}(&members[i])
+	}
+	wg.Wait()
+	return members, nil
+}
+
+// poll reads the contact raft has with a follower, and asks the follower for its
+// replication state.
+func (m *Monitor) poll(member *Member, leaderIndex uint64) {
+	m.mu.Lock()
+	since, failing := m.failing[raft.ServerID(member.NodeID)]
+	m.mu.Unlock()
+	reachable := !failing
+	member.Reachable = &reachable
+	if failing {
+		member.LastContact = time.Since(since).String()
+	}
+
+	state, err := m.followerState(member.HTTPAddress)
+	if err != nil {
+		member.Error = err.Error()
+		return
+	}
+
+	if !failing {
+		member.LastContact = state.LastContact
+	}
+	member.LastLogIndex = &state.LastLogIndex
+	lag := uint64(0)
+	if leaderIndex > state.LastLogIndex {
+		lag = leaderIndex - state.LastLogIndex
+	}
+	member.Lag = &lag
+}
+
+// followerState is the part of the readiness report of a member the monitor reads.
+type followerState struct {
+	LastContact  string `json:"last_contact"`
+	LastLogIndex uint64 `json:"last_log_index"`
+}
+
+func (m *Monitor) followerState(address string) (*followerState, error) {
+	if address == "" {
+		return nil, fmt.Errorf("http address unknown")
+	}
+
+	// Readiness answers 503 with the same data while the member is behind, so
+	// both are read.
+	resp, err := m.client.Get(strings.TrimSuffix(address, "/") + "/health/ready")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var report struct {
+		Data followerState `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, fmt.Errorf("error decoding readiness: %s", err)
+	}
+	return &report.Data, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// clientTimeout bounds requests the CLI sends to a running node.
+const clientTimeout = 30 * time.Second
+
+// clientFlags are shared by the commands that talk to a running node.
+func clientFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "address",
+			Value:   "http://localhost:2221",
+			Usage:   "The HTTP address of the node",
+			EnvVars: []string{"ARIMA_ADDRESS"},
+			Aliases: []string{"a"},
+		},
+		&cli.StringFlag{
+			Name:    "token",
+			Usage:   "The bearer token to authenticate with when ACLs are enabled",
+			EnvVars: []string{"ARIMA_TOKEN"},
+		},
+		&cli.PathFlag{
+			Name:  "ca-file",
+			Usage: "The CA bundle used to verify the node when its API is served over TLS",
+		},
+	}
+}
+
+// apiClient sends requests to the HTTP API of a running node.
+type apiClient struct {
+	address string
+	token   string
+	http    *http.Client
+}
+
+func newAPIClient(c *cli.Context) (*apiClient, error) {
+	client := &http.Client{Timeout: clientTimeout}
+	if caFile := c.Path("ca-file"); caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("CA file holds no certificates")
+		}
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	}
+
+	return &apiClient{
+		address: strings.TrimSuffix(c.String("address"), "/"),
+		token:   c.String("token"),
+		http:    client,
+	}, nil
+}
+
+// do sends body as JSON and decodes the JSON response. Responses outside 2xx are
+// returned as errors carrying the error message of the node.
+func (a *apiClient) do(method, path string, body interface{}) (map[string]interface{}, error) {
+	resp, err := a.send(method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	out := map[string]interface{}{}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("error decoding response: %s", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s: %s: %v", method, path, resp.Status, out["error"])
+	}
+	return out, nil
+}
+
+func (a *apiClient) send(method, path string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, a.address+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+	return a.http.Do(req)
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/urfave/cli/v2"
@@ -18,35 +20,164 @@ type configRaft struct {
 
 // configServer configuration for HTTP server
 type configServer struct {
-	Port int `mapstructure:"port"`
+	Port            int           `mapstructure:"port"`
+	AdvertiseURL    string        `mapstructure:"advertise_url"`
+	ReadyMaxLag     uint64        `mapstructure:"ready_max_lag"`
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
 }
 
-// config configuration
-type config struct {
-	Server configServer `mapstructure:"server"`
-	Raft   configRaft   `mapstructure:"raft"`
+// configACL configuration for token based access control
+type configACL struct {
+	Enabled     bool   `mapstructure:"enabled"`
+	MasterToken string `mapstructure:"master_token"`
 }
 
-const (
-	// The maxPool controls how many connections we will pool.
-	maxPool = 3
+// configTLS configuration for TLS on the HTTP API and mutual TLS between raft peers
+type configTLS struct {
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+	CAFile   string `mapstructure:"ca_file"`
+	HTTP     bool   `mapstructure:"http"`
+	Raft     bool   `mapstructure:"raft"`
+}
 
-	// The timeout is used to apply I/O deadlines. For InstallSnapshot, we multiply
-	// the timeout by (SnapshotSize / TimeoutScale).
-	tcpTimeout = 10 * time.Second
+// configStorage configuration for the badger stores
+type configStorage struct {
+	EncryptionKeyFile         string        `mapstructure:"encryption_key_file"`
+	PreviousEncryptionKeyFile string        `mapstructure:"previous_encryption_key_file"`
+	EncryptionKeyRotation     time.Duration `mapstructure:"encryption_key_rotation"`
+	IndexCacheSize            int64         `mapstructure:"index_cache_size"`
+	FullSnapshotInterval      int           `mapstructure:"full_snapshot_interval"`
+	SnapshotCompression       string        `mapstructure:"snapshot_compression"`
+	LogCacheSize              int           `mapstructure:"log_cache_size"`
+	UnifiedStorage            bool          `mapstructure:"unified_storage"`
+	Engine                    string        `mapstructure:"engine"`
+	InMemory                  bool          `mapstructure:"in_memory"`
+}
 
-	// The `retain` parameter controls how many
-	// snapshots are retained. Must be at least 1.
-	raftSnapShotRetain = 2
-)
+// configAutopilot configuration for automatic membership management on the leader
+type configAutopilot struct {
+	Interval             time.Duration `mapstructure:"interval"`
+	LastContactThreshold time.Duration `mapstructure:"last_contact_threshold"`
+	MaxLag               uint64        `mapstructure:"max_lag"`
+	DeadServerGrace      time.Duration `mapstructure:"dead_server_grace"`
+	MinVoters            int           `mapstructure:"min_voters"`
+	StabilizationTime    time.Duration `mapstructure:"stabilization_time"`
+}
+
+// config configuration
+type config struct {
+	Server    configServer    `mapstructure:"server"`
+	Raft      configRaft      `mapstructure:"raft"`
+	ACL       configACL       `mapstructure:"acl"`
+	TLS       configTLS       `mapstructure:"tls"`
+	Storage   configStorage   `mapstructure:"storage"`
+	Autopilot configAutopilot `mapstructure:"autopilot"`
+}
 
 var (
 	svport    string
 	raftport  string
 	nodeid    string
 	volumedir string
+
+	advertiseURL    string
+	readyMaxLag     uint64
+	shutdownTimeout time.Duration
+
+	aclEnabled     bool
+	aclMasterToken string
+
+	tlsCertFile string
+	tlsKeyFile  string
+	tlsCAFile   string
+	tlsHTTP     bool
+	tlsRaft     bool
+
+	encryptionKeyFile         string
+	previousEncryptionKeyFile string
+	encryptionKeyRotation     time.Duration
+	indexCacheSize            int64
+	fullSnapshotInterval      int
+	snapshotCompression       string
+	logCacheSize              int
+	unifiedStorage            bool
+	storageEngine             string
+	inMemory                  bool
+
+	autopilotInterval             time.Duration
+	autopilotLastContactThreshold time.Duration
+	autopilotMaxLag               uint64
+	autopilotDeadServerGrace      time.Duration
+	autopilotMinVoters            int
+	autopilotStabilizationTime    time.Duration
 )
 
+// encryptionKeyEnv holds the base64 encoded encryption key when no key file is given.
+const encryptionKeyEnv = "ARIMA_ENCRYPTION_KEY"
+
+// newConfig builds the node configuration from the command line flags
+func newConfig() (config, error) {
+	serverPort, err := strconv.Atoi(svport)
+	if err != nil {
+		return config{}, err
+	}
+
+	raftPort, err := strconv.Atoi(raftport)
+	if err != nil {
+		return config{}, err
+	}
+
+	if volumedir == "" && !inMemory {
+		return config{}, errors.New("--volume-dir is required unless --in-memory is set")
+	}
+
+	return config{
+		Server: configServer{
+			Port:            serverPort,
+			AdvertiseURL:    advertiseURL,
+			ReadyMaxLag:     readyMaxLag,
+			ShutdownTimeout: shutdownTimeout,
+		},
+		Raft: configRaft{
+			NodeId:    nodeid,
+			Port:      raftPort,
+			VolumeDir: volumedir,
+		},
+		ACL: configACL{
+			Enabled:     aclEnabled,
+			MasterToken: aclMasterToken,
+		},
+		TLS: configTLS{
+			CertFile: tlsCertFile,
+			KeyFile:  tlsKeyFile,
+			CAFile:   tlsCAFile,
+			HTTP:     tlsHTTP,
+			Raft:     tlsRaft,
+		},
+		Storage: configStorage{
+			EncryptionKeyFile:         encryptionKeyFile,
+			PreviousEncryptionKeyFile: previousEncryptionKeyFile,
+			EncryptionKeyRotation:     encryptionKeyRotation,
+			IndexCacheSize:            indexCacheSize,
+			FullSnapshotInterval:      fullSnapshotInterval,
+			SnapshotCompression:       snapshotCompression,
+			LogCacheSize:              logCacheSize,
+			UnifiedStorage:            unifiedStorage,
+			Engine:                    storageEngine,
+			InMemory:                  inMemory,
+		},
+		Autopilot: configAutopilot{
+			Interval:             autopilotInterval,
+			LastContactThreshold: autopilotLastContactThreshold,
+			MaxLag:               autopilotMaxLag,
+			DeadServerGrace:      autopilotDeadServerGrace,
+			MinVoters:            autopilotMinVoters,
+			StabilizationTime:    autopilotStabilizationTime,
+		},
+	}, nil
+}
+
 func main() {
 	app := &cli.App{
 		Name:                 "arima",
@@ -86,21 +217,170 @@ func main() {
 					&cli.PathFlag{
 						Name:        "volume-dir",
 						Value:       "",
-						Usage:       "The directory to store the data",
-						Required:    true,
+						Usage:       "The directory to store the data, required unless --in-memory is set",
 						Aliases:     []string{"v"},
 						Destination: &volumedir,
 					},
+					&cli.StringFlag{
+						Name:        "advertise-url",
+						Usage:       "The URL other nodes reach the HTTP API of this node at, defaults to http(s)://127.0.0.1:<server-port>",
+						Destination: &advertiseURL,
+					},
+					&cli.Uint64Flag{
+						Name:        "ready-max-lag",
+						Value:       1000,
+						Usage:       "How many log entries the node may have left to apply and still report ready",
+						Destination: &readyMaxLag,
+					},
+					&cli.DurationFlag{
+						Name:        "shutdown-timeout",
+						Value:       30 * time.Second,
+						Usage:       "How long a graceful shutdown may take on SIGINT or SIGTERM",
+						Destination: &shutdownTimeout,
+					},
+					&cli.BoolFlag{
+						Name:        "acl-enabled",
+						Usage:       "Require a bearer token on every request",
+						Destination: &aclEnabled,
+					},
+					&cli.StringFlag{
+						Name:        "acl-master-token",
+						Value:       "",
+						Usage:       "A token that is always granted full privileges, used to bootstrap ACLs",
+						EnvVars:     []string{"ARIMA_ACL_MASTER_TOKEN"},
+						Destination: &aclMasterToken,
+					},
+					&cli.PathFlag{
+						Name:        "tls-cert-file",
+						Usage:       "The certificate of this node, issued to its node id for raft mutual TLS",
+						Destination: &tlsCertFile,
+					},
+					&cli.PathFlag{
+						Name:        "tls-key-file",
+						Usage:       "The private key of the node certificate",
+						Destination: &tlsKeyFile,
+					},
+					&cli.PathFlag{
+						Name:        "tls-ca-file",
+						Usage:       "The CA bundle used to verify raft peers",
+						Destination: &tlsCAFile,
+					},
+					&cli.BoolFlag{
+						Name:        "tls-http",
+						Usage:       "Serve the HTTP API over TLS",
+						Destination: &tlsHTTP,
+					},
+					&cli.BoolFlag{
+						Name:        "tls-raft",
+						Usage:       "Use mutual TLS between raft peers",
+						Destination: &tlsRaft,
+					},
+					&cli.PathFlag{
+						Name:        "encryption-key-file",
+						Usage:       "A file holding the raw 16, 24 or 32 byte AES key data at rest and snapshots are encrypted with; " + encryptionKeyEnv + " may hold it base64 encoded instead",
+						Destination: &encryptionKeyFile,
+					},
+					&cli.PathFlag{
+						Name:        "previous-encryption-key-file",
+						Usage:       "The key used before the last rotation, to read snapshots encrypted with it",
+						Destination: &previousEncryptionKeyFile,
+					},
+					&cli.DurationFlag{
+						Name:        "encryption-key-rotation",
+						Usage:       "How often badger rotates the data keys derived from the encryption key",
+						Destination: &encryptionKeyRotation,
+					},
+					&cli.Int64Flag{
+						Name:        "index-cache-size",
+						Usage:       "The badger index cache size in bytes, defaults to 100MB when encryption is enabled",
+						Destination: &indexCacheSize,
+					},
+					&cli.IntFlag{
+						Name:        "full-snapshot-interval",
+						Value:       8,
+						Usage:       "Take a full snapshot every N snapshots, the others only hold the changes since the previous one; 1 disables incremental snapshots",
+						Destination: &fullSnapshotInterval,
+					},
+					&cli.StringFlag{
+						Name:        "snapshot-compression",
+						Value:       "zstd",
+						Usage:       "The codec snapshots and backups are compressed with, zstd or none",
+						Destination: &snapshotCompression,
+					},
+					&cli.IntFlag{
+						Name:        "log-cache-size",
+						Value:       512,
+						Usage:       "The number of recent raft log entries kept in memory; 0 disables the cache",
+						Destination: &logCacheSize,
+					},
+					&cli.BoolFlag{
+						Name:        "unified-storage",
+						Usage:       "Keep the raft log, the stable store and the FSM in a single badger database, migrating a volume that uses separate ones",
+						Destination: &unifiedStorage,
+					},
+					&cli.StringFlag{
+						Name:        "storage-engine",
+						Value:       "badger",
+						Usage:       "The engine the FSM keeps its state in, badger or memory; memory rebuilds the state from the snapshots and the raft log on start",
+						Destination: &storageEngine,
+					},
+					&cli.BoolFlag{
+						Name:        "in-memory",
+						Usage:       "Keep the FSM, the raft log and the snapshots in memory, for tests and throwaway clusters; nothing survives a restart. Only the storage is in memory: raft still listens on --raft-port",
+						Destination: &inMemory,
+					},
+					&cli.DurationFlag{
+						Name:        "autopilot-interval",
+						Value:       2 * time.Second,
+						Usage:       "How often the leader checks the health of the servers",
+						Destination: &autopilotInterval,
+					},
+					&cli.DurationFlag{
+						Name:        "autopilot-last-contact-threshold",
+						Value:       time.Second,
+						Usage:       "How long a server may go without hearing from the leader and still be healthy",
+						Destination: &autopilotLastContactThreshold,
+					},
+					&cli.Uint64Flag{
+						Name:        "autopilot-max-lag",
+						Value:       250,
+						Usage:       "How many log entries a server may trail the leader and still be healthy",
+						Destination: &autopilotMaxLag,
+					},
+					&cli.DurationFlag{
+						Name:        "autopilot-dead-server-grace",
+						Usage:       "How long a server must be unhealthy before autopilot removes it, 0 disables removal",
+						Destination: &autopilotDeadServerGrace,
+					},
+					&cli.IntFlag{
+						Name:        "autopilot-min-voters",
+						Usage:       "How many voters autopilot keeps by promoting healthy non-voters, 0 disables promotion",
+						Destination: &autopilotMinVoters,
+					},
+					&cli.DurationFlag{
+						Name:        "autopilot-stabilization-time",
+						Value:       10 * time.Second,
+						Usage:       "How long a non-voter must be healthy before autopilot promotes it",
+						Destination: &autopilotStabilizationTime,
+					},
 				},
 				Action: func(c *cli.Context) error {
 					fmt.Println("Starting arima")
-					err := startNode(svport, raftport, nodeid, volumedir)
+					conf, err := newConfig()
+					if err != nil {
+						return err
+					}
+					err = startNode(conf)
 					if err != nil {
 						return err
 					}
 					return nil
 				},
 			},
+			rotateKeyCommand(),
+			transferLeadershipCommand(),
+			recoverCommand(),
+			restoreCommand(),
 		},
 	}
 	err := app.Run(os.Args)
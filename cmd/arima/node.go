@@ -1,99 +1,122 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"net"
 	"os"
-	"path/filepath"
-	"strconv"
+	"os/signal"
+	"syscall"
 
-	"github.com/hashicorp/raft"
-	"github.com/rohankmr414/arima/fsm"
-	"github.com/rohankmr414/arima/server"
+	"github.com/rohankmr414/arima"
+	"github.com/rohankmr414/arima/autopilot"
+	"github.com/rohankmr414/arima/encryption"
+	"github.com/rohankmr414/arima/metrics"
 	"github.com/rohankmr414/arima/store"
 )
 
-func startNode(svport, raftport, nodeid, volumedir string) error {
-	serverPort, err := strconv.Atoi(svport)
-	if err != nil {
+func startNode(conf config) error {
+	logConf := conf
+	if logConf.ACL.MasterToken != "" {
+		logConf.ACL.MasterToken = "<redacted>"
+	}
+	log.Printf("%+v\n", logConf)
+
+	if err := metrics.Setup(); err != nil {
 		return err
 	}
 
-	raftPort, err := strconv.Atoi(raftport)
+	storeOpts, err := storeOptions(conf.Storage)
 	if err != nil {
 		return err
 	}
 
-	conf := config{
-		Server: configServer{
-			Port: serverPort,
+	node, err := arima.New(arima.Options{
+		NodeID:         conf.Raft.NodeId,
+		RaftAddress:    fmt.Sprintf("localhost:%d", conf.Raft.Port),
+		HTTPAddress:    fmt.Sprintf(":%d", conf.Server.Port),
+		AdvertiseURL:   conf.Server.AdvertiseURL,
+		ReadyMaxLag:    conf.Server.ReadyMaxLag,
+		ACLEnabled:     conf.ACL.Enabled,
+		ACLMasterToken: conf.ACL.MasterToken,
+		TLS: arima.TLSOptions{
+			CertFile: conf.TLS.CertFile,
+			KeyFile:  conf.TLS.KeyFile,
+			CAFile:   conf.TLS.CAFile,
+			HTTP:     conf.TLS.HTTP,
+			Raft:     conf.TLS.Raft,
 		},
-		Raft: configRaft{
-			NodeId:    nodeid,
-			Port:      raftPort,
-			VolumeDir: volumedir,
+		VolumeDir: conf.Raft.VolumeDir,
+		Storage: arima.StorageOptions{
+			Options:        storeOpts,
+			LogCacheSize:   conf.Storage.LogCacheSize,
+			UnifiedStorage: conf.Storage.UnifiedStorage,
+			Engine:         conf.Storage.Engine,
+			InMemory:       conf.Storage.InMemory,
 		},
-	}
-
-	log.Printf("%+v\n", conf)
-
-	raftBindAddr := fmt.Sprintf("localhost:%d", conf.Raft.Port)
-
-	raftConf := raft.DefaultConfig()
-	raftConf.LocalID = raft.ServerID(conf.Raft.NodeId)
-
-	arimaFsm, err := fsm.NewArimaFSM(conf.Raft.VolumeDir)
+		Autopilot: autopilot.Config{
+			Interval:             conf.Autopilot.Interval,
+			LastContactThreshold: conf.Autopilot.LastContactThreshold,
+			MaxLag:               conf.Autopilot.MaxLag,
+			DeadServerGrace:      conf.Autopilot.DeadServerGrace,
+			MinVoters:            conf.Autopilot.MinVoters,
+			StabilizationTime:    conf.Autopilot.StabilizationTime,
+		},
+		Metrics: true,
+	})
 	if err != nil {
 		return err
 	}
-
-	arimaLogStore, err := store.NewLogStore(filepath.Join(conf.Raft.VolumeDir, "log"))
-	if err != nil {
+	if err := node.Start(context.Background()); err != nil {
 		return err
 	}
 
-	arimaStableStore, err := store.NewStableStore(filepath.Join(conf.Raft.VolumeDir, "stable"))
-	if err != nil {
-		return err
-	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
 
-	arimaSnapshotStore, err := raft.NewFileSnapshotStore(conf.Raft.VolumeDir, raftSnapShotRetain, os.Stdout)
-	if err != nil {
-		return err
+	var startErr error
+	select {
+	case sig := <-signals:
+		log.Printf("Received %s, shutting down", sig)
+	case err := <-node.Err():
+		startErr = fmt.Errorf("failed to start server: %s", err)
 	}
 
-	tcpAddr, err := net.ResolveTCPAddr("tcp", raftBindAddr)
-	if err != nil {
-		return fmt.Errorf("error resolving TCP address: %s", err)
+	ctx, cancel := context.WithTimeout(context.Background(), conf.Server.ShutdownTimeout)
+	defer cancel()
+	err = node.Shutdown(ctx)
+	if startErr != nil {
+		return startErr
 	}
+	return err
+}
 
-	transport, err := raft.NewTCPTransport(raftBindAddr, tcpAddr, maxPool, tcpTimeout, os.Stdout)
+// storeOptions loads the encryption keys and builds the options of the badger stores.
+func storeOptions(conf configStorage) (store.Options, error) {
+	key, err := encryption.LoadKey(conf.EncryptionKeyFile, encryptionKeyEnv)
 	if err != nil {
-		return fmt.Errorf("error creating TCP transport: %s", err)
+		return store.Options{}, err
 	}
 
-	raftServer, err := raft.NewRaft(raftConf, arimaFsm, arimaLogStore, arimaStableStore, arimaSnapshotStore, transport)
-	if err != nil {
-		return err
+	opts := store.Options{
+		EncryptionKey:         key,
+		EncryptionKeyRotation: conf.EncryptionKeyRotation,
+		IndexCacheSize:        conf.IndexCacheSize,
+		FullSnapshotInterval:  conf.FullSnapshotInterval,
+		SnapshotCompression:   conf.SnapshotCompression,
 	}
 
-	// always start single server as a leader
-	configuration := raft.Configuration{
-		Servers: []raft.Server{
-			{
-				ID:      raft.ServerID(conf.Raft.NodeId),
-				Address: transport.LocalAddr(),
-			},
-		},
+	if conf.PreviousEncryptionKeyFile != "" {
+		previous, err := encryption.LoadKey(conf.PreviousEncryptionKeyFile, "")
+		if err != nil {
+			return store.Options{}, err
+		}
+		opts.PreviousEncryptionKeys = [][]byte{previous}
 	}
 
-	raftServer.BootstrapCluster(configuration)
-
-	srv := server.New(fmt.Sprintf(":%d", conf.Server.Port), arimaFsm.Conn, raftServer)
-	if err = srv.Start(); err != nil {
-		return fmt.Errorf("failed to start server: %s", err)
+	if key != nil {
+		log.Println("Encryption at rest is enabled")
 	}
-
-	return nil
+	return opts, nil
 }
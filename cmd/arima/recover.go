@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/raft"
+	"github.com/rohankmr414/arima"
+	"github.com/rohankmr414/arima/store"
+	"github.com/urfave/cli/v2"
+)
+
+// recoverCommand rewrites the raft configuration of a stopped node, so a cluster
+// that lost its quorum can be restarted from the surviving servers.
+func recoverCommand() *cli.Command {
+	var (
+		dir                  string
+		nodeID               string
+		recoveryFile         string
+		keyFile              string
+		previousKeyFile      string
+		fullSnapshotInterval int
+		snapshotCompression  string
+		unifiedStorage       bool
+		storageEngine        string
+	)
+	return &cli.Command{
+		Name:  "recover",
+		Usage: "Recover a stopped node of a cluster that lost its quorum",
+		Description: "Run this on every surviving node while all of them are stopped, with the same recovery file, " +
+			"then start them again. The recovery file is a JSON list of the surviving servers, such as\n\n" +
+			"   [{\"id\": \"n1\", \"address\": \"127.0.0.1:1111\", \"non_voter\": false}]\n\n" +
+			"Committed entries are applied to the FSM, which is then snapshotted, and the configuration is replaced. " +
+			"Entries that were never committed by the lost quorum may be applied, so only recover when the lost " +
+			"servers cannot be brought back.",
+		Flags: []cli.Flag{
+			&cli.PathFlag{
+				Name:        "volume-dir",
+				Usage:       "The directory the node stores its data in",
+				Required:    true,
+				Aliases:     []string{"v"},
+				Destination: &dir,
+			},
+			&cli.StringFlag{
+				Name:        "node-id",
+				Usage:       "The raft node id of this node, which must be listed in the recovery file",
+				Required:    true,
+				Aliases:     []string{"i"},
+				Destination: &nodeID,
+			},
+			&cli.PathFlag{
+				Name:        "recovery-file",
+				Usage:       "The JSON file listing the surviving servers",
+				Required:    true,
+				Destination: &recoveryFile,
+			},
+			&cli.PathFlag{
+				Name:        "encryption-key-file",
+				Usage:       "The key the node's data is encrypted with; " + encryptionKeyEnv + " may hold it base64 encoded instead",
+				Destination: &keyFile,
+			},
+			&cli.PathFlag{
+				Name:        "previous-encryption-key-file",
+				Usage:       "The key used before the last rotation, to read snapshots encrypted with it",
+				Destination: &previousKeyFile,
+			},
+			&cli.IntFlag{
+				Name:        "full-snapshot-interval",
+				Value:       8,
+				Usage:       "The full snapshot interval the node runs with, so the snapshots incremental ones build on are kept",
+				Destination: &fullSnapshotInterval,
+			},
+			&cli.StringFlag{
+				Name:        "snapshot-compression",
+				Value:       "zstd",
+				Usage:       "The codec the recovery snapshot is compressed with, zstd or none",
+				Destination: &snapshotCompression,
+			},
+			&cli.BoolFlag{
+				Name:        "unified-storage",
+				Usage:       "The node keeps the raft log, the stable store and the FSM in a single badger database",
+				Destination: &unifiedStorage,
+			},
+			&cli.StringFlag{
+				Name:        "storage-engine",
+				Value:       "badger",
+				Usage:       "The engine the node's FSM keeps its state in, badger or memory",
+				Destination: &storageEngine,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			configuration, err := raft.ReadConfigJSON(recoveryFile)
+			if err != nil {
+				return fmt.Errorf("error reading recovery file: %s", err)
+			}
+			storeOpts, err := storeOptions(configStorage{
+				EncryptionKeyFile:         keyFile,
+				PreviousEncryptionKeyFile: previousKeyFile,
+				FullSnapshotInterval:      fullSnapshotInterval,
+				SnapshotCompression:       snapshotCompression,
+			})
+			if err != nil {
+				return err
+			}
+			return recoverNode(dir, raft.ServerID(nodeID), configuration, arima.StorageOptions{
+				Options:        storeOpts,
+				UnifiedStorage: unifiedStorage,
+				Engine:         storageEngine,
+			})
+		},
+	}
+}
+
+func recoverNode(dir string, nodeID raft.ServerID, configuration raft.Configuration, conf arima.StorageOptions) error {
+	listed := false
+	for _, server := range configuration.Servers {
+		log.Printf("Recovery configuration: %s at %s (%s)", server.ID, server.Address, server.Suffrage)
+		listed = listed || server.ID == nodeID
+	}
+	if !listed {
+		return fmt.Errorf("node %s is not listed in the recovery file", nodeID)
+	}
+
+	_, separateErr := os.Stat(filepath.Join(dir, "log"))
+	if _, err := os.Stat(filepath.Join(dir, store.UnifiedDir)); err != nil && separateErr != nil {
+		return fmt.Errorf("no raft log found in %s: %s", dir, separateErr)
+	}
+
+	// Badger locks its directories, so opening the stores fails while the node
+	// is still running.
+	stores, err := arima.OpenStores(dir, conf)
+	if err != nil {
+		return fmt.Errorf("error opening stores, is the node stopped? %s", err)
+	}
+	defer stores.Close()
+	arimaFsm, logStore, stableStore := stores.FSM, stores.Log, stores.Stable
+
+	snapshotStore, err := store.NewSnapshotStore(dir, arima.SnapshotRetain, conf.FullSnapshotInterval, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	first, err := logStore.FirstIndex()
+	if err != nil {
+		return err
+	}
+	last, err := logStore.LastIndex()
+	if err != nil {
+		return err
+	}
+	log.Printf("Recovering node %s with log entries %d to %d", nodeID, first, last)
+
+	raftConf := raft.DefaultConfig()
+	raftConf.LocalID = nodeID
+	_, transport := raft.NewInmemTransport("")
+	err = raft.RecoverCluster(raftConf, arimaFsm, logStore, stableStore, snapshotStore, transport, configuration)
+	if err != nil {
+		return fmt.Errorf("error recovering cluster: %s", err)
+	}
+
+	log.Printf("Recovered node %s, start it again to form the recovered cluster", nodeID)
+	return nil
+}
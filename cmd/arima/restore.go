@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+
+	"github.com/hashicorp/raft"
+	"github.com/rohankmr414/arima"
+	"github.com/rohankmr414/arima/backup"
+	"github.com/rohankmr414/arima/store"
+	"github.com/urfave/cli/v2"
+)
+
+// restoreCommand seeds the volume of a new single node cluster from a backup.
+func restoreCommand() *cli.Command {
+	var (
+		dir             string
+		nodeID          string
+		raftPort        int
+		raftAddress     string
+		backupFile      string
+		keyFile         string
+		previousKeyFile string
+		unifiedStorage  bool
+		storageEngine   string
+	)
+	return &cli.Command{
+		Name:  "restore",
+		Usage: "Seed a new single node cluster from a backup",
+		Description: "The backup is written to the empty volume directory as the node's first raft snapshot, " +
+			"so nodes that join later receive it. Start the node with the same --node-id, --raft-port and storage flags afterwards.",
+		Flags: []cli.Flag{
+			&cli.PathFlag{
+				Name:        "volume-dir",
+				Usage:       "The empty directory the new node will store its data in",
+				Required:    true,
+				Aliases:     []string{"v"},
+				Destination: &dir,
+			},
+			&cli.StringFlag{
+				Name:        "node-id",
+				Usage:       "The raft node id of the new node",
+				Required:    true,
+				Aliases:     []string{"i"},
+				Destination: &nodeID,
+			},
+			&cli.IntFlag{
+				Name:        "raft-port",
+				Usage:       "The port the new node will listen on for raft requests",
+				Aliases:     []string{"r"},
+				Destination: &raftPort,
+			},
+			&cli.StringFlag{
+				Name:        "raft-address",
+				Usage:       "The host:port other nodes will reach the new node's raft at, localhost:<raft-port> by default",
+				Destination: &raftAddress,
+			},
+			&cli.PathFlag{
+				Name:        "backup-file",
+				Usage:       "The backup taken from /admin/backup",
+				Required:    true,
+				Destination: &backupFile,
+			},
+			&cli.PathFlag{
+				Name:        "encryption-key-file",
+				Usage:       "The key the new node encrypts its data with; " + encryptionKeyEnv + " may hold it base64 encoded instead",
+				Destination: &keyFile,
+			},
+			&cli.PathFlag{
+				Name:        "previous-encryption-key-file",
+				Usage:       "The key the backup is encrypted with, when it differs from the new node's key",
+				Destination: &previousKeyFile,
+			},
+			&cli.BoolFlag{
+				Name:        "unified-storage",
+				Usage:       "Keep the raft log, the stable store and the FSM in a single badger database",
+				Destination: &unifiedStorage,
+			},
+			&cli.StringFlag{
+				Name:        "storage-engine",
+				Value:       "badger",
+				Usage:       "The engine the FSM keeps its state in, badger or memory",
+				Destination: &storageEngine,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			storeOpts, err := storeOptions(configStorage{
+				EncryptionKeyFile:         keyFile,
+				PreviousEncryptionKeyFile: previousKeyFile,
+			})
+			if err != nil {
+				return err
+			}
+			if raftAddress == "" {
+				if raftPort == 0 {
+					return errors.New("either --raft-port or --raft-address is required")
+				}
+				raftAddress = fmt.Sprintf("localhost:%d", raftPort)
+			}
+			return restoreNode(dir, raft.ServerID(nodeID), raftAddress, backupFile, arima.StorageOptions{
+				Options:        storeOpts,
+				UnifiedStorage: unifiedStorage,
+				Engine:         storageEngine,
+			})
+		},
+	}
+}
+
+func restoreNode(dir string, nodeID raft.ServerID, raftAddress, backupFile string, conf arima.StorageOptions) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("volume directory %s is not empty", dir)
+	}
+
+	f, err := os.Open(backupFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	header, err := backup.ReadHeader(r)
+	if err != nil {
+		return err
+	}
+	log.Printf("Restoring backup of index %d, term %d, taken at %s", header.Index, header.Term, header.CreatedAt)
+
+	stores, err := arima.OpenStores(dir, conf)
+	if err != nil {
+		return err
+	}
+	defer stores.Close()
+	arimaFsm := stores.FSM
+	var dump io.Reader = r
+	if !header.Envelope {
+		dump = io.MultiReader(bytes.NewReader(store.LegacyMagic), r)
+	}
+	if err := arimaFsm.Restore(ioutil.NopCloser(dump)); err != nil {
+		return fmt.Errorf("error loading backup: %s", err)
+	}
+
+	// Raft indexes start at 1, so even an empty backup becomes a snapshot.
+	index, term := header.Index, header.Term
+	if index == 0 {
+		index = 1
+	}
+	if term == 0 {
+		term = 1
+	}
+
+	addr, err := net.ResolveTCPAddr("tcp", raftAddress)
+	if err != nil {
+		return fmt.Errorf("error resolving TCP address: %s", err)
+	}
+	configuration := raft.Configuration{
+		Servers: []raft.Server{
+			{
+				Suffrage: raft.Voter,
+				ID:       nodeID,
+				Address:  raft.ServerAddress(addr.String()),
+			},
+		},
+	}
+
+	snapshotStore, err := store.NewSnapshotStore(dir, arima.SnapshotRetain, 0, os.Stdout)
+	if err != nil {
+		return err
+	}
+	_, transport := raft.NewInmemTransport("")
+	sink, err := snapshotStore.Create(raft.SnapshotVersionMax, index, term, configuration, index, transport)
+	if err != nil {
+		return err
+	}
+	snapshot, err := arimaFsm.Snapshot()
+	if err != nil {
+		sink.Cancel()
+		return err
+	}
+	defer snapshot.Release()
+	if err := snapshot.Persist(sink); err != nil {
+		return fmt.Errorf("error writing snapshot: %s", err)
+	}
+
+	if err := stores.Stable.SetUint64([]byte("CurrentTerm"), term); err != nil {
+		return err
+	}
+
+	log.Printf("Restored node %s at %s, start it to run the restored cluster", nodeID, addr)
+	return nil
+}
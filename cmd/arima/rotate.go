@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/rohankmr414/arima"
+	"github.com/rohankmr414/arima/encryption"
+	"github.com/rohankmr414/arima/fsm"
+	"github.com/rohankmr414/arima/store"
+	"github.com/urfave/cli/v2"
+)
+
+// rotateKeyCommand re-encrypts the key registries of the three badger stores and
+// every snapshot of a stopped node with a new encryption key.
+func rotateKeyCommand() *cli.Command {
+	var (
+		dir     string
+		oldFile string
+		newFile string
+	)
+	return &cli.Command{
+		Name:  "rotate-key",
+		Usage: "Re-encrypt the data of a stopped node with a new encryption key",
+		Description: "Badger encrypts its data with data keys which are themselves encrypted with the encryption key. " +
+			"Rotating only rewrites the data keys and the snapshots. Leave --old-key-file empty to encrypt a " +
+			"node that was unencrypted, or --new-key-file empty to decrypt it.",
+		Flags: []cli.Flag{
+			&cli.PathFlag{
+				Name:        "volume-dir",
+				Usage:       "The directory the node stores its data in",
+				Required:    true,
+				Aliases:     []string{"v"},
+				Destination: &dir,
+			},
+			&cli.PathFlag{
+				Name:        "old-key-file",
+				Usage:       "The key the data is currently encrypted with",
+				Destination: &oldFile,
+			},
+			&cli.PathFlag{
+				Name:        "new-key-file",
+				Usage:       "The key to encrypt the data with",
+				Destination: &newFile,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			oldKey, err := encryption.LoadKey(oldFile, "")
+			if err != nil {
+				return err
+			}
+			newKey, err := encryption.LoadKey(newFile, "")
+			if err != nil {
+				return err
+			}
+			return rotateKey(dir, oldKey, newKey)
+		},
+	}
+}
+
+func rotateKey(dir string, oldKey, newKey []byte) error {
+	dirs, err := arima.StoreDirs(dir)
+	if err != nil {
+		return err
+	}
+	for _, db := range dirs {
+		if err := rotateKeyRegistry(db, oldKey, newKey); err != nil {
+			return err
+		}
+	}
+
+	snapshots, err := filepath.Glob(filepath.Join(dir, "snapshots", "*", "state.bin"))
+	if err != nil {
+		return err
+	}
+	// Snapshots a previous, interrupted run already re-encrypted are read with
+	// the new key.
+	from := encryption.NewKeyring(oldKey, newKey)
+	if len(oldKey) == 0 {
+		from = encryption.NewKeyring(newKey)
+	}
+	for _, state := range snapshots {
+		if err := reencryptSnapshotFile(state, from, newKey); err != nil {
+			return fmt.Errorf("error re-encrypting snapshot %s: %s", filepath.Dir(state), err)
+		}
+		log.Printf("Re-encrypted snapshot %s", filepath.Base(filepath.Dir(state)))
+	}
+	return nil
+}
+
+// rotateKeyRegistry re-encrypts the key registry of a badger database, skipping
+// one a previous run already rotated.
+func rotateKeyRegistry(db string, oldKey, newKey []byte) error {
+	opt := badger.KeyRegistryOptions{
+		Dir:                           db,
+		ReadOnly:                      true,
+		EncryptionKey:                 oldKey,
+		EncryptionKeyRotationDuration: 10 * 24 * time.Hour,
+	}
+	kr, err := badger.OpenKeyRegistry(opt)
+	if err == badger.ErrEncryptionKeyMismatch {
+		opt.EncryptionKey = newKey
+		if kr, err := badger.OpenKeyRegistry(opt); err == nil {
+			kr.Close()
+			log.Printf("Key registry in %s is already encrypted with the new key", db)
+			return nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error opening key registry in %s: %s", db, err)
+	}
+	defer kr.Close()
+	opt.EncryptionKey = newKey
+	if err := badger.WriteKeyRegistry(kr, opt); err != nil {
+		return fmt.Errorf("error writing key registry in %s: %s", db, err)
+	}
+	log.Printf("Rotated key registry in %s", db)
+	return nil
+}
+
+// reencryptSnapshotFile rewrites the state of a file snapshot and updates the size
+// and checksum the file snapshot store keeps next to it. Both are written to
+// temporary files first and meta.json is renamed last. Once the temporary
+// meta.json is complete the new state is too, so a rerun after a crash finishes
+// the renames instead of re-encrypting the snapshot again.
+func reencryptSnapshotFile(statePath string, from *encryption.Keyring, to []byte) error {
+	dir := filepath.Dir(statePath)
+	metaPath := filepath.Join(dir, "meta.json")
+	stateTmp, metaTmp := statePath+".tmp", metaPath+".tmp"
+
+	if data, err := ioutil.ReadFile(metaTmp); err == nil && json.Valid(data) {
+		log.Printf("Finishing the interrupted re-encryption of snapshot %s", filepath.Base(dir))
+		return replaceSnapshotFiles(dir, statePath, metaPath)
+	}
+	for _, tmp := range []string{stateTmp, metaTmp} {
+		if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	in, err := os.Open(statePath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(stateTmp)
+	if err != nil {
+		return err
+	}
+	hash := crc64.New(crc64.MakeTable(crc64.ECMA))
+	counter := &countingWriter{w: io.MultiWriter(out, hash)}
+	err = reencryptState(bufio.NewReader(in), counter, from, to)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(stateTmp)
+		return err
+	}
+
+	data, err := ioutil.ReadFile(metaPath)
+	if err != nil {
+		return err
+	}
+	meta := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return err
+	}
+	if meta["Size"], err = json.Marshal(counter.n); err != nil {
+		return err
+	}
+	if meta["CRC"], err = json.Marshal(hash.Sum(nil)); err != nil {
+		return err
+	}
+	data, err = json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if err := writeFileSync(metaTmp, data); err != nil {
+		return err
+	}
+	return replaceSnapshotFiles(dir, statePath, metaPath)
+}
+
+// replaceSnapshotFiles moves the temporary state and meta.json of a snapshot in
+// place, meta.json last. The state is already in place when a previous run
+// stopped between the two renames.
+func replaceSnapshotFiles(dir, statePath, metaPath string) error {
+	if err := os.Rename(statePath+".tmp", statePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := syncDir(dir); err != nil {
+		return err
+	}
+	if err := os.Rename(metaPath+".tmp", metaPath); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// writeFileSync writes data to path and flushes it to disk.
+func writeFileSync(path string, data []byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir flushes the renames made in dir to disk.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// reencryptState re-encrypts the state of a snapshot. The legacy mark and the
+// header of incremental snapshots are kept as they are and each segment of a
+// chain is re-encrypted on its own.
+func reencryptState(r *bufio.Reader, w io.Writer, from *encryption.Keyring, to []byte) error {
+	legacy, err := store.ReadLegacyMagic(r)
+	if err != nil {
+		return err
+	}
+	if legacy {
+		if _, err := w.Write(store.LegacyMagic); err != nil {
+			return err
+		}
+	}
+	parent, ok, err := store.ReadDeltaHeader(r)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if err := store.WriteDeltaHeader(w, parent); err != nil {
+			return err
+		}
+		return fsm.ReencryptSnapshot(r, w, from, to)
+	}
+	if !store.IsChain(r) {
+		return fsm.ReencryptSnapshot(r, w, from, to)
+	}
+
+	if _, err := w.Write(store.ChainMagic); err != nil {
+		return err
+	}
+	// The size of a re-encrypted segment is only known once written, so each
+	// goes through a temporary file first.
+	return store.ReadSegments(r, func(segment *bufio.Reader) error {
+		tmp, err := ioutil.TempFile("", "arima-segment")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		if err := fsm.ReencryptSnapshot(segment, tmp, from, to); err != nil {
+			return err
+		}
+		size, err := tmp.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		return store.WriteSegment(w, tmp, size)
+	})
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
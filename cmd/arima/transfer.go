@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/urfave/cli/v2"
+)
+
+// transferLeadershipCommand moves leadership off the node at --address, which
+// must be the leader.
+func transferLeadershipCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "transfer-leadership",
+		Usage: "Hand leadership to another voter, before maintenance on the leader",
+		Flags: append(clientFlags(),
+			&cli.StringFlag{
+				Name:  "node-id",
+				Usage: "The node to transfer leadership to, the most up to date voter when empty",
+			},
+		),
+		Action: func(c *cli.Context) error {
+			client, err := newAPIClient(c)
+			if err != nil {
+				return err
+			}
+			resp, err := client.do(http.MethodPost, "/raft/transfer-leadership", map[string]string{
+				"node_id": c.String("node-id"),
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Println(resp["message"])
+			return nil
+		},
+	}
+}
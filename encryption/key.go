@@ -0,0 +1,81 @@
+package encryption
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// LoadKey reads an AES key from file, or from the environment variable env when
+// file is empty. Key files hold the raw key, as badger's own tooling expects; the
+// environment variable holds it base64 encoded. The key must be 16, 24 or 32 bytes.
+// It returns nil when neither source is set.
+func LoadKey(file, env string) ([]byte, error) {
+	var key []byte
+	switch {
+	case file != "":
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading encryption key file: %s", err)
+		}
+		key = data
+	case env != "" && os.Getenv(env) != "":
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(os.Getenv(env)))
+		if err != nil {
+			return nil, fmt.Errorf("error decoding encryption key from %s: %s", env, err)
+		}
+		key = data
+	default:
+		return nil, nil
+	}
+
+	if !validLength(len(key)) {
+		return nil, fmt.Errorf("encryption key must be 16, 24 or 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+func validLength(n int) bool {
+	return n == 16 || n == 24 || n == 32
+}
+
+// Fingerprint identifies a key without revealing it.
+func Fingerprint(key []byte) [8]byte {
+	sum := sha256.Sum256(append([]byte("arima-key-fingerprint"), key...))
+	var fp [8]byte
+	copy(fp[:], sum[:8])
+	return fp
+}
+
+// Keyring holds the key new data is encrypted with, and previous keys that data
+// written before a rotation can still be decrypted with.
+type Keyring struct {
+	current  []byte
+	previous [][]byte
+}
+
+// NewKeyring returns nil when current is empty, meaning encryption is disabled.
+func NewKeyring(current []byte, previous ...[]byte) *Keyring {
+	if len(current) == 0 {
+		return nil
+	}
+	return &Keyring{current: current, previous: previous}
+}
+
+// Current returns the key new data is encrypted with.
+func (k *Keyring) Current() []byte {
+	return k.current
+}
+
+// Lookup returns the key with the given fingerprint.
+func (k *Keyring) Lookup(fp [8]byte) ([]byte, bool) {
+	for _, key := range append([][]byte{k.current}, k.previous...) {
+		if len(key) > 0 && Fingerprint(key) == fp {
+			return key, true
+		}
+	}
+	return nil, false
+}
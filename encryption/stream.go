@@ -0,0 +1,304 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Magic starts every encrypted stream.
+var Magic = []byte("ARIMAENC")
+
+const (
+	// streamVersion 1 sealed every stream with the key itself, told apart by a
+	// 4 byte nonce prefix. It is still read.
+	streamVersion       = 2
+	legacyStreamVersion = 1
+
+	// chunkSize is the amount of plaintext sealed at once.
+	chunkSize = 64 * 1024
+
+	noncePrefixSize = 4
+	saltSize        = 32
+)
+
+// streamKeyInfo binds the keys derived for streams to their purpose.
+var streamKeyInfo = []byte("arima-stream-key")
+
+var (
+	ErrTruncated  = errors.New("encrypted stream is truncated")
+	ErrUnknownKey = errors.New("encrypted stream uses a key that is not configured")
+)
+
+// The stream is the header
+//
+//	magic | version (1) | key fingerprint (8) | salt (32)
+//
+// followed by chunks of
+//
+//	ciphertext length (4, big endian) | AES-GCM ciphertext
+//
+// Every stream is sealed with a key of its own, derived from the key and the
+// random salt with HKDF-SHA256, so nonces never repeat across streams under one
+// key. Each chunk's nonce is the chunk counter, and the last chunk is
+// authenticated as such, so reordered, dropped or truncated chunks fail to
+// decrypt.
+
+type writer struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	counter uint64
+	buf     []byte
+	closed  bool
+}
+
+// NewWriter encrypts everything written to the returned writer into w. Close must
+// be called to write the final chunk; it does not close w.
+func NewWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	streamKey, err := deriveStreamKey(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(streamKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ew := &writer{w: w, aead: aead, buf: make([]byte, 0, chunkSize)}
+	fp := Fingerprint(key)
+	header := append(append([]byte{}, Magic...), streamVersion)
+	header = append(header, fp[:]...)
+	header = append(header, salt...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return ew, nil
+}
+
+func (ew *writer) Write(p []byte) (int, error) {
+	if ew.closed {
+		return 0, errors.New("write to closed encrypted stream")
+	}
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data follows, so the last chunk
+		// is always sealed by Close.
+		if len(ew.buf) == chunkSize {
+			if err := ew.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(ew.buf[len(ew.buf):chunkSize], p)
+		ew.buf = ew.buf[:len(ew.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (ew *writer) Close() error {
+	if ew.closed {
+		return nil
+	}
+	ew.closed = true
+	return ew.seal(true)
+}
+
+func (ew *writer) seal(final bool) error {
+	nonce := chunkNonce([noncePrefixSize]byte{}, ew.counter)
+	sealed := ew.aead.Seal(nil, nonce, ew.buf, chunkAD(ew.counter, final))
+	ew.counter++
+	ew.buf = ew.buf[:0]
+
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(sealed)))
+	if _, err := ew.w.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := ew.w.Write(sealed)
+	return err
+}
+
+type reader struct {
+	r    io.Reader
+	aead cipher.AEAD
+	// prefix is only set in legacy streams; the nonces of the others are the
+	// chunk counter alone.
+	prefix  [noncePrefixSize]byte
+	counter uint64
+	buf     []byte
+	next    []byte
+	done    bool
+}
+
+// NewReader decrypts a stream written by NewWriter, with the key of the keyring
+// matching the stream's key fingerprint.
+func NewReader(r io.Reader, keyring *Keyring) (io.Reader, error) {
+	header := make([]byte, len(Magic)+1+8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("error reading encryption header: %s", err)
+	}
+	if !bytes.Equal(header[:len(Magic)], Magic) {
+		return nil, errors.New("stream is not encrypted")
+	}
+	version := header[len(Magic)]
+	var params []byte
+	switch version {
+	case streamVersion:
+		params = make([]byte, saltSize)
+	case legacyStreamVersion:
+		params = make([]byte, noncePrefixSize)
+	default:
+		return nil, fmt.Errorf("unsupported encrypted stream version %d", version)
+	}
+	if _, err := io.ReadFull(r, params); err != nil {
+		return nil, fmt.Errorf("error reading encryption header: %s", err)
+	}
+	if keyring == nil {
+		return nil, errors.New("stream is encrypted but no encryption key is configured")
+	}
+
+	var fp [8]byte
+	copy(fp[:], header[len(Magic)+1:])
+	key, ok := keyring.Lookup(fp)
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	er := &reader{r: r}
+	if version == streamVersion {
+		var err error
+		if key, err = deriveStreamKey(key, params); err != nil {
+			return nil, err
+		}
+	} else {
+		copy(er.prefix[:], params)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	er.aead = aead
+	return er, nil
+}
+
+func (er *reader) Read(p []byte) (int, error) {
+	for len(er.buf) == 0 {
+		if er.done {
+			return 0, io.EOF
+		}
+		if err := er.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, er.buf)
+	er.buf = er.buf[n:]
+	return n, nil
+}
+
+// open decrypts the next chunk. A chunk is only known to be the last one once
+// the stream ends after it, so one chunk is read ahead.
+func (er *reader) open() error {
+	current := er.next
+	if current == nil {
+		var err error
+		if current, err = er.readChunk(); err != nil {
+			return err
+		}
+		if current == nil {
+			return ErrTruncated
+		}
+	}
+	next, err := er.readChunk()
+	if err != nil {
+		return err
+	}
+	er.next = next
+	final := next == nil
+
+	plain, err := er.aead.Open(nil, chunkNonce(er.prefix, er.counter), current, chunkAD(er.counter, final))
+	if err != nil {
+		if final {
+			return ErrTruncated
+		}
+		return fmt.Errorf("error decrypting chunk %d: %s", er.counter, err)
+	}
+	er.counter++
+	er.buf = plain
+	er.done = final
+	return nil
+}
+
+// readChunk returns nil at the end of the stream.
+func (er *reader) readChunk() ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(er.r, length[:]); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, ErrTruncated
+	}
+	n := binary.BigEndian.Uint32(length[:])
+	if n > chunkSize+uint32(er.aead.Overhead()) {
+		return nil, fmt.Errorf("encrypted chunk of %d bytes is too large", n)
+	}
+	chunk := make([]byte, n)
+	if _, err := io.ReadFull(er.r, chunk); err != nil {
+		return nil, ErrTruncated
+	}
+	return chunk, nil
+}
+
+// IsEncrypted reports whether the buffered stream starts with the encryption magic,
+// without consuming it.
+func IsEncrypted(r *bufio.Reader) bool {
+	head, err := r.Peek(len(Magic))
+	return err == nil && bytes.Equal(head, Magic)
+}
+
+// deriveStreamKey derives the key a stream is sealed with from key and the salt
+// of the stream, of the same length as key.
+func deriveStreamKey(key, salt []byte) ([]byte, error) {
+	streamKey := make([]byte, len(key))
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, salt, streamKeyInfo), streamKey); err != nil {
+		return nil, fmt.Errorf("error deriving stream key: %s", err)
+	}
+	return streamKey, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix [noncePrefixSize]byte, counter uint64) []byte {
+	nonce := make([]byte, noncePrefixSize+8)
+	copy(nonce, prefix[:])
+	binary.BigEndian.PutUint64(nonce[noncePrefixSize:], counter)
+	return nonce
+}
+
+func chunkAD(counter uint64, final bool) []byte {
+	ad := make([]byte, 9)
+	binary.BigEndian.PutUint64(ad, counter)
+	if final {
+		ad[8] = 1
+	}
+	return ad
+}
@@ -0,0 +1,190 @@
+package fsm
+
+import (
+	"github.com/rohankmr414/arima/acl"
+	"github.com/rohankmr414/arima/store"
+	"github.com/rohankmr414/arima/utils"
+)
+
+var (
+	aclPolicyPrefix = reservedKey("acl", "policy", "")
+	aclTokenPrefix  = reservedKey("acl", "token", "")
+	aclSecretPrefix = reservedKey("acl", "secret", "")
+)
+
+func aclPolicyKey(name string) []byte {
+	return append(append([]byte{}, aclPolicyPrefix...), name...)
+}
+
+func aclTokenKey(accessorID string) []byte {
+	return append(append([]byte{}, aclTokenPrefix...), accessorID...)
+}
+
+func aclSecretKey(secretHash string) []byte {
+	return append(append([]byte{}, aclSecretPrefix...), secretHash...)
+}
+
+// applyACL handles the acl_policy_* and acl_token_* operations.
+func (fsm *ArimaFSM) applyACL(payload CommandPayload) error {
+	return fsm.update(func(txn store.Txn) error {
+		switch payload.Operation {
+		case "acl_policy_set":
+			var policy acl.Policy
+			if err := utils.DecodeMsgPack(payload.Value, &policy); err != nil {
+				return err
+			}
+			return writeMsgPack(txn, aclPolicyKey(policy.Name), policy)
+		case "acl_policy_delete":
+			return txn.Delete(aclPolicyKey(string(payload.Key)))
+		case "acl_token_set":
+			var token acl.Token
+			if err := utils.DecodeMsgPack(payload.Value, &token); err != nil {
+				return err
+			}
+			var existing acl.Token
+			found, err := readMsgPack(txn, aclTokenKey(token.AccessorID), &existing)
+			if err != nil {
+				return err
+			}
+			if found {
+				if token.SecretHash == "" {
+					token.SecretHash = existing.SecretHash
+				} else if token.SecretHash != existing.SecretHash {
+					if err := txn.Delete(aclSecretKey(existing.SecretHash)); err != nil {
+						return err
+					}
+				}
+			}
+			if err := txn.Set(aclSecretKey(token.SecretHash), []byte(token.AccessorID)); err != nil {
+				return err
+			}
+			return writeMsgPack(txn, aclTokenKey(token.AccessorID), token)
+		case "acl_token_delete":
+			var existing acl.Token
+			found, err := readMsgPack(txn, aclTokenKey(string(payload.Key)), &existing)
+			if err != nil || !found {
+				return err
+			}
+			if err := txn.Delete(aclSecretKey(existing.SecretHash)); err != nil {
+				return err
+			}
+			return txn.Delete(aclTokenKey(existing.AccessorID))
+		}
+		return nil
+	})
+}
+
+// ACLPolicy returns the policy with the given name.
+func (fsm *ArimaFSM) ACLPolicy(name string) (*acl.Policy, error) {
+	var policy acl.Policy
+	err := fsm.view(func(txn store.Txn) error {
+		found, err := readMsgPack(txn, aclPolicyKey(name), &policy)
+		if err == nil && !found {
+			err = store.ErrNotFound
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// ACLPolicies returns all policies ordered by name.
+func (fsm *ArimaFSM) ACLPolicies() ([]acl.Policy, error) {
+	policies := []acl.Policy{}
+	err := fsm.view(func(txn store.Txn) error {
+		it := txn.NewIterator(store.IteratorOptions{})
+		defer it.Close()
+
+		for it.Seek(aclPolicyPrefix); it.ValidForPrefix(aclPolicyPrefix); it.Next() {
+			var policy acl.Policy
+			if err := it.Item().Value(func(val []byte) error {
+				return utils.DecodeMsgPack(val, &policy)
+			}); err != nil {
+				return err
+			}
+			policies = append(policies, policy)
+		}
+		return nil
+	})
+	return policies, err
+}
+
+// ACLToken returns the token with the given accessor ID.
+func (fsm *ArimaFSM) ACLToken(accessorID string) (*acl.Token, error) {
+	var token acl.Token
+	err := fsm.view(func(txn store.Txn) error {
+		found, err := readMsgPack(txn, aclTokenKey(accessorID), &token)
+		if err == nil && !found {
+			err = store.ErrNotFound
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ACLTokens returns all tokens ordered by accessor ID.
+func (fsm *ArimaFSM) ACLTokens() ([]acl.Token, error) {
+	tokens := []acl.Token{}
+	err := fsm.view(func(txn store.Txn) error {
+		it := txn.NewIterator(store.IteratorOptions{})
+		defer it.Close()
+
+		for it.Seek(aclTokenPrefix); it.ValidForPrefix(aclTokenPrefix); it.Next() {
+			var token acl.Token
+			if err := it.Item().Value(func(val []byte) error {
+				return utils.DecodeMsgPack(val, &token)
+			}); err != nil {
+				return err
+			}
+			tokens = append(tokens, token)
+		}
+		return nil
+	})
+	return tokens, err
+}
+
+// ResolveACLToken looks up the token owning secret together with its policies.
+// Policies that no longer exist are skipped.
+func (fsm *ArimaFSM) ResolveACLToken(secret string) (*acl.Token, []acl.Policy, error) {
+	var (
+		token    acl.Token
+		policies []acl.Policy
+	)
+	err := fsm.view(func(txn store.Txn) error {
+		item, err := txn.Get(aclSecretKey(acl.HashSecret(secret)))
+		if err != nil {
+			return err
+		}
+		accessorID, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		found, err := readMsgPack(txn, aclTokenKey(string(accessorID)), &token)
+		if err == nil && !found {
+			err = store.ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		for _, name := range token.Policies {
+			var policy acl.Policy
+			found, err := readMsgPack(txn, aclPolicyKey(name), &policy)
+			if err != nil {
+				return err
+			}
+			if found {
+				policies = append(policies, policy)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &token, policies, nil
+}
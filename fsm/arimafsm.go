@@ -1,15 +1,50 @@
 package fsm
 
 import (
+	"bufio"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
 
-	"github.com/dgraph-io/badger/v3"
 	"github.com/hashicorp/raft"
+	"github.com/rohankmr414/arima/encryption"
+	"github.com/rohankmr414/arima/store"
 	"github.com/rohankmr414/arima/utils"
 )
 
 type ArimaFSM struct {
-	Conn *badger.DB
+	// db is replaced when a snapshot is restored. Reads and writes hold dbMu
+	// for reading, the swap holds it for writing.
+	dbMu sync.RWMutex
+	db   store.Engine
+	// data holds db and stages restored snapshots next to it.
+	data store.FSMStore
+
+	keyring *encryption.Keyring
+	// codec compresses snapshots and backups.
+	codec byte
+
+	// mu serializes commands with PointInTime, which records the index and
+	// term of the last command applied.
+	mu           sync.Mutex
+	appliedIndex uint64
+	appliedTerm  uint64
+
+	// fullSnapshotInterval bounds the length of snapshot chains.
+	fullSnapshotInterval int
+	// chain is the last snapshot persisted, which the next incremental snapshot
+	// builds on. It is guarded by mu.
+	chain snapshotChain
+	// restores counts the snapshots restored, so a snapshot taken before a
+	// restore never becomes the base of a chain.
+	restores uint64
 }
 
 // type LogStruct struct {
@@ -18,19 +53,36 @@ type ArimaFSM struct {
 // 	Val []byte
 // }
 
-func NewArimaFSM(path string) (*ArimaFSM, error) {
-	var err error
-	opts := badger.DefaultOptions(path)
-	opts.Logger = nil
-	opts.SyncWrites = true
+func NewArimaFSM(path string, opts store.Options) (*ArimaFSM, error) {
+	data, err := store.NewFSMStore(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	fsm, err := NewArimaFSMWithStore(data, opts)
+	if err != nil {
+		data.Close()
+		return nil, err
+	}
+	return fsm, nil
+}
 
-	handle, err := badger.Open(opts)
+// NewArimaFSMWithStore returns an FSM keeping its state in data, which it
+// closes when closed.
+func NewArimaFSMWithStore(data store.FSMStore, opts store.Options) (*ArimaFSM, error) {
+	codec, err := ParseCodec(opts.SnapshotCompression)
 	if err != nil {
 		return nil, err
 	}
+	if err := ensureUsage(data.View()); err != nil {
+		return nil, err
+	}
 
 	return &ArimaFSM{
-		Conn: handle,
+		db:                   data.View(),
+		data:                 data,
+		keyring:              opts.Keyring(),
+		codec:                codec,
+		fullSnapshotInterval: opts.FullSnapshotInterval,
 	}, nil
 }
 
@@ -60,33 +112,66 @@ func (fsm *ArimaFSM) Apply(log *raft.Log) interface{} {
 	// }
 	// return nil
 	if log.Type == raft.LogCommand {
+		defer metrics.MeasureSince([]string{"fsm", "apply"}, time.Now())
+
+		fsm.mu.Lock()
+		defer fsm.mu.Unlock()
+		fsm.appliedIndex, fsm.appliedTerm = log.Index, log.Term
+
 		var payload CommandPayload
 		if err := utils.DecodeMsgPack(log.Data, &payload); err != nil {
+			metrics.IncrCounterWithLabels([]string{"fsm", "apply", "errors"}, 1,
+				[]metrics.Label{{Name: "operation", Value: "decode"}})
 			return err
 		}
-		if payload.Operation == "set" {
-			return &ApplyResponse{
-				Error: fsm.Conn.Update(func(txn *badger.Txn) error {
-					return txn.Set(payload.Key, payload.Value)
-				}),
-				Data: payload.Value,
-			}
-		} else if payload.Operation == "delete" {
+
+		resp := fsm.applyCommand(payload)
+		if r, ok := resp.(*ApplyResponse); ok && r.Error != nil {
+			metrics.IncrCounterWithLabels([]string{"fsm", "apply", "errors"}, 1,
+				[]metrics.Label{{Name: "operation", Value: payload.Operation}})
+		}
+		return resp
+	}
+
+	return nil
+}
+
+// applyCommand applies a decoded command payload to the store.
+func (fsm *ArimaFSM) applyCommand(payload CommandPayload) interface{} {
+	switch payload.Operation {
+	case "set":
+		return &ApplyResponse{
+			Error: fsm.setKey(payload.Key, payload.Value),
+			Data:  payload.Value,
+		}
+	case "delete":
+		return &ApplyResponse{
+			Error: fsm.deleteKey(payload.Key),
+			Data:  nil,
+		}
+	case "get":
+		data, err := fsm.Get(payload.Key)
+		if err != nil {
 			return &ApplyResponse{
-				Error: fsm.Conn.Update(func(txn *badger.Txn) error {
-					return txn.Delete(payload.Key)
-				}),
-				Data: nil,
-			}
-		} else if payload.Operation == "get" {
-			data, err := fsm.Get(payload.Key)
-			if err != nil {
-				return &ApplyResponse{
-					Error: err,
-					Data:  data,
-				}
+				Error: err,
+				Data:  data,
 			}
 		}
+	case "quota_set", "quota_delete":
+		return &ApplyResponse{
+			Error: fsm.applyQuota(payload),
+			Data:  nil,
+		}
+	case "acl_policy_set", "acl_policy_delete", "acl_token_set", "acl_token_delete":
+		return &ApplyResponse{
+			Error: fsm.applyACL(payload),
+			Data:  nil,
+		}
+	case "member_set", "member_delete":
+		return &ApplyResponse{
+			Error: fsm.applyMember(payload),
+			Data:  nil,
+		}
 	}
 
 	return nil
@@ -94,28 +179,140 @@ func (fsm *ArimaFSM) Apply(log *raft.Log) interface{} {
 
 // Snapshot is used to support log compaction. This call should
 // return an FSMSnapshot which can be used to save a point-in-time snapshot of the FSM.
+//
+// The snapshot holds the database until it is released, so a restore waits for
+// it to be persisted.
 func (fsm *ArimaFSM) Snapshot() (raft.FSMSnapshot, error) {
-	return &ArimaSnapshot{Conn: fsm.Conn}, nil
+	fsm.dbMu.RLock()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+
+	snap := &ArimaSnapshot{
+		keyring:  fsm.keyring,
+		codec:    fsm.codec,
+		fsm:      fsm,
+		txn:      fsm.db.Snapshot(),
+		restores: fsm.restores,
+		length:   1,
+	}
+	if fsm.chain.id != "" && fsm.chain.length < fsm.fullSnapshotInterval {
+		snap.parentID = fsm.chain.id
+		snap.after = fsm.chain.version
+		snap.length = fsm.chain.length + 1
+	}
+	return snap, nil
 }
 
 // Restore is used to restore an FSM from a snapshot. It is not called
 // concurrently with any other command. The FSM must discard all previous
 // state.
+//
+// The snapshot is spooled to disk and verified, then loaded next to the current
+// state, which is only replaced once the load succeeded.
 func (fsm *ArimaFSM) Restore(r io.ReadCloser) error {
-	err := fsm.Conn.DropAll()
+	defer metrics.MeasureSince([]string{"fsm", "snapshot", "restore"}, time.Now())
+
+	var (
+		spool *os.File
+		err   error
+	)
+	if path := fsm.data.SpoolPath(); path != "" {
+		spool, err = os.Create(path)
+	} else {
+		spool, err = ioutil.TempFile("", "arima-snapshot-")
+	}
 	if err != nil {
 		return err
 	}
-	err = fsm.Conn.Load(r, 100)
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	size, err := io.Copy(spool, r)
 	if err != nil {
+		return fmt.Errorf("error reading snapshot: %s", err)
+	}
+	log.Printf("Received snapshot of %s, verifying it", formatBytes(size))
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := fsm.eachSegment(spool, verifyEnvelope); err != nil {
+		metrics.IncrCounter([]string{"fsm", "snapshot", "restore", "rejected"}, 1)
+		return fmt.Errorf("refusing to restore snapshot: %s", err)
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
 		return err
 	}
+
+	staging, err := fsm.data.Stage()
+	if err != nil {
+		return err
+	}
+	if err := fsm.load(staging, newProgressReader(spool, size)); err != nil {
+		if aerr := fsm.data.Abort(); aerr != nil {
+			log.Printf("error dropping the partially restored snapshot: %s", aerr)
+		}
+		return fmt.Errorf("error loading snapshot, keeping the current state: %s", err)
+	}
+
+	fsm.dbMu.Lock()
+	defer fsm.dbMu.Unlock()
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	fsm.appliedIndex, fsm.appliedTerm = 0, 0
+	fsm.restores++
+	fsm.chain.reset()
+
+	err = fsm.data.Commit()
+	fsm.db = fsm.data.View()
+	if err != nil {
+		return err
+	}
+	log.Printf("Restored snapshot of %s", formatBytes(size))
 	return nil
 }
 
+// load loads a snapshot into the empty view db.
+func (fsm *ArimaFSM) load(db store.Engine, r io.Reader) error {
+	// Chains are loaded segment by segment, each newer segment overriding the
+	// keys of the previous ones.
+	err := fsm.eachSegment(r, func(segment *bufio.Reader, legacy bool) error {
+		src, err := openEnvelope(segment, legacy)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		return load(db, src)
+	})
+	if err != nil {
+		return err
+	}
+	return rebuildUsage(db)
+}
+
+// eachSegment calls fn with the decrypted content of every segment of a
+// snapshot, and whether the snapshot starts with store.LegacyMagic, in which case
+// its segments may predate the envelope.
+func (fsm *ArimaFSM) eachSegment(r io.Reader, fn func(segment *bufio.Reader, legacy bool) error) error {
+	br := bufio.NewReader(r)
+	legacy, err := store.ReadLegacyMagic(br)
+	if err != nil {
+		return err
+	}
+	return store.ReadSegments(br, func(segment *bufio.Reader) error {
+		if !encryption.IsEncrypted(segment) {
+			return fn(segment, legacy)
+		}
+		src, err := encryption.NewReader(segment, fsm.keyring)
+		if err != nil {
+			return fmt.Errorf("error decrypting snapshot: %s", err)
+		}
+		return fn(bufio.NewReader(src), legacy)
+	})
+}
+
 func (fsm *ArimaFSM) Get(key []byte) ([]byte, error) {
 	var val []byte
-	err := fsm.Conn.View(func(txn *badger.Txn) error {
+	err := fsm.view(func(txn store.Txn) error {
 		item, err := txn.Get(key)
 		if err != nil {
 			return err
@@ -128,3 +325,72 @@ func (fsm *ArimaFSM) Get(key []byte) ([]byte, error) {
 	}
 	return val, nil
 }
+
+// setKey sets a key from the store API, accounting it against its namespace quota.
+func (fsm *ArimaFSM) setKey(key, value []byte) error {
+	if IsReserved(key) {
+		return ErrReservedKey
+	}
+	return fsm.update(func(txn store.Txn) error {
+		return setAccounted(txn, key, value)
+	})
+}
+
+// deleteKey deletes a key from the store API, releasing its namespace usage.
+func (fsm *ArimaFSM) deleteKey(key []byte) error {
+	if IsReserved(key) {
+		return ErrReservedKey
+	}
+	return fsm.update(func(txn store.Txn) error {
+		return deleteAccounted(txn, key)
+	})
+}
+
+// Ping checks the store is open and can sync to disk. Nothing is written, since
+// the FSM may only change through raft.
+func (fsm *ArimaFSM) Ping() error {
+	fsm.dbMu.RLock()
+	defer fsm.dbMu.RUnlock()
+	if fsm.db.IsClosed() {
+		return errors.New("fsm store is closed")
+	}
+	return fsm.db.Sync()
+}
+
+// view runs fn in a read-only transaction of the current database.
+func (fsm *ArimaFSM) view(fn func(txn store.Txn) error) error {
+	fsm.dbMu.RLock()
+	defer fsm.dbMu.RUnlock()
+	return fsm.db.View(fn)
+}
+
+// update runs fn in a read-write transaction of the current database.
+func (fsm *ArimaFSM) update(fn func(txn store.Txn) error) error {
+	fsm.dbMu.RLock()
+	defer fsm.dbMu.RUnlock()
+	return fsm.db.Update(fn)
+}
+
+// Size returns the LSM and value log sizes of the current database.
+func (fsm *ArimaFSM) Size() (lsm, vlog int64) {
+	fsm.dbMu.RLock()
+	defer fsm.dbMu.RUnlock()
+	return fsm.db.Size()
+}
+
+// IsClosed reports whether the database is closed.
+func (fsm *ArimaFSM) IsClosed() bool {
+	fsm.dbMu.RLock()
+	defer fsm.dbMu.RUnlock()
+	return fsm.db.IsClosed()
+}
+
+// Close closes the store.
+func (fsm *ArimaFSM) Close() error {
+	fsm.dbMu.Lock()
+	defer fsm.dbMu.Unlock()
+	fsm.mu.Lock()
+	fsm.chain.reset()
+	fsm.mu.Unlock()
+	return fsm.data.Close()
+}
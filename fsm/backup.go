@@ -0,0 +1,188 @@
+package fsm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/dgraph-io/badger/v3/pb"
+	"github.com/rohankmr414/arima/store"
+)
+
+const (
+	// dumpBatchSize is the amount of key-value data written per badger KV list.
+	dumpBatchSize = 4 << 20
+
+	// bitDelete is badger's meta bit for deletion markers, which Load replays.
+	bitDelete byte = 1 << 0
+)
+
+// PointInTime is a consistent view of the store, pinned between two raft commands
+// and spooled to a temporary file.
+type PointInTime struct {
+	// Index and Term are those of the last raft command the view includes, or
+	// zero when no command was applied since the store was last restored.
+	Index uint64
+	Term  uint64
+
+	spool *os.File
+}
+
+// PointInTime writes the current state of the store to a temporary file, in the
+// snapshot format Restore reads. The database is only held while the file is
+// written, so a slow reader of the view does not hold up restoring a snapshot.
+// Release must be called once the view is no longer needed.
+func (fsm *ArimaFSM) PointInTime() (*PointInTime, error) {
+	fsm.dbMu.RLock()
+	defer fsm.dbMu.RUnlock()
+
+	fsm.mu.Lock()
+	p := &PointInTime{
+		Index: fsm.appliedIndex,
+		Term:  fsm.appliedTerm,
+	}
+	txn := fsm.db.Snapshot()
+	fsm.mu.Unlock()
+	defer txn.Discard()
+
+	dir := ""
+	if path := fsm.data.SpoolPath(); path != "" {
+		dir = filepath.Dir(path)
+	}
+	spool, err := ioutil.TempFile(dir, "backup-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	p.spool = spool
+	if err := writeState(spool, fsm.keyring, fsm.codec, txn, 0); err != nil {
+		p.Release()
+		return nil, err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		p.Release()
+		return nil, err
+	}
+	return p, nil
+}
+
+// Write copies the view to w.
+func (p *PointInTime) Write(w io.Writer) error {
+	_, err := io.Copy(w, p.spool)
+	return err
+}
+
+// Release removes the spooled view.
+func (p *PointInTime) Release() {
+	p.spool.Close()
+	os.Remove(p.spool.Name())
+}
+
+// dump writes the keys of the snapshot as length prefixed KV lists, the format
+// badger's Load reads. Unlike badger's Backup, which streams from several
+// transactions, everything is read from the one snapshot.
+//
+// With after set, only keys changed since that version are written, deleted
+// keys as deletion markers, so the output can be loaded on top of a dump taken
+// at that version.
+func dump(txn store.Snapshot, w io.Writer, after uint64) error {
+	it := txn.NewIterator(store.IteratorOptions{Since: after})
+	defer it.Close()
+
+	list := &pb.KVList{}
+	size := 0
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		kv := &pb.KV{
+			Key:      item.KeyCopy(nil),
+			UserMeta: []byte{0},
+			Version:  item.Version(),
+		}
+		if item.IsDeleted() {
+			kv.Meta = []byte{bitDelete}
+		} else {
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			kv.Value = val
+		}
+		list.Kv = append(list.Kv, kv)
+		size += len(kv.Key) + len(kv.Value)
+		if size >= dumpBatchSize {
+			if err := writeKVList(w, list); err != nil {
+				return err
+			}
+			list, size = &pb.KVList{}, 0
+		}
+	}
+	if len(list.Kv) == 0 {
+		return nil
+	}
+	return writeKVList(w, list)
+}
+
+func writeKVList(w io.Writer, list *pb.KVList) error {
+	buf, err := list.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint64(len(buf))); err != nil {
+		return err
+	}
+	_, err = w.Write(buf)
+	return err
+}
+
+// load writes the KV lists read from r into db, replaying deletion markers. The
+// keys are written through the engine rather than with badger's Load, so the
+// snapshot can be loaded into any engine; the versions of the dump are not
+// kept, later lists override earlier ones.
+func load(db store.Engine, r io.Reader) error {
+	br := bufio.NewReaderSize(r, 16<<10)
+	batch := db.NewBatch()
+	defer batch.Cancel()
+
+	var buf []byte
+	for {
+		var size uint64
+		err := binary.Read(br, binary.LittleEndian, &size)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if uint64(cap(buf)) < size {
+			buf = make([]byte, size)
+		}
+		if _, err := io.ReadFull(br, buf[:size]); err != nil {
+			return err
+		}
+		list := &pb.KVList{}
+		if err := list.Unmarshal(buf[:size]); err != nil {
+			return err
+		}
+
+		var last []byte
+		for _, kv := range list.Kv {
+			// Older versions of a key follow the newest one, which wins.
+			if last != nil && bytes.Equal(kv.Key, last) {
+				continue
+			}
+			last = kv.Key
+			if len(kv.Meta) > 0 && kv.Meta[0]&bitDelete != 0 {
+				err = batch.Delete(kv.Key)
+			} else {
+				err = batch.Set(kv.Key, kv.Value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return batch.Flush()
+}
@@ -0,0 +1,199 @@
+package fsm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// SchemaVersion is the version of the layout of the keys the FSM stores,
+// reserved keys included. Snapshots of a newer schema are refused.
+const SchemaVersion = 1
+
+// Snapshot envelope, inside the encryption of a snapshot when enabled:
+//
+//	magic | format version (1) | schema version (4) | codec (1) | body | CRC-32C (4)
+//
+// The body holds the KV lists badger's Load reads, compressed with the codec.
+// The checksum covers everything before it.
+const (
+	snapshotFormatVersion = 1
+
+	codecNone byte = 0
+	codecZstd byte = 1
+
+	envelopeHeaderSize = 8 + 1 + 4 + 1
+	checksumSize       = 4
+)
+
+var snapshotMagic = []byte("ARIMASNP")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrCorruptSnapshot is returned when a snapshot is truncated or its checksum
+// does not match.
+var ErrCorruptSnapshot = errors.New("corrupt snapshot")
+
+// ParseCodec returns the codec of a snapshot compression name.
+func ParseCodec(name string) (byte, error) {
+	switch name {
+	case "", "zstd":
+		return codecZstd, nil
+	case "none":
+		return codecNone, nil
+	}
+	return 0, fmt.Errorf("unknown snapshot compression %q", name)
+}
+
+type envelopeWriter struct {
+	w    io.Writer
+	crc  hash.Hash32
+	body io.WriteCloser
+}
+
+// newEnvelopeWriter writes the header of a snapshot envelope to w. Closing the
+// writer ends the body and writes the checksum.
+func newEnvelopeWriter(w io.Writer, codec byte) (io.WriteCloser, error) {
+	crc := crc32.New(crcTable)
+	tee := io.MultiWriter(w, crc)
+
+	header := make([]byte, 0, envelopeHeaderSize)
+	header = append(header, snapshotMagic...)
+	header = append(header, snapshotFormatVersion)
+	header = append(header, 0, 0, 0, 0, codec)
+	binary.BigEndian.PutUint32(header[9:13], SchemaVersion)
+	if _, err := tee.Write(header); err != nil {
+		return nil, err
+	}
+
+	ew := &envelopeWriter{w: w, crc: crc, body: nopCloser{tee}}
+	if codec == codecZstd {
+		zw, err := zstd.NewWriter(tee)
+		if err != nil {
+			return nil, err
+		}
+		ew.body = zw
+	}
+	return ew, nil
+}
+
+func (ew *envelopeWriter) Write(p []byte) (int, error) {
+	return ew.body.Write(p)
+}
+
+func (ew *envelopeWriter) Close() error {
+	if err := ew.body.Close(); err != nil {
+		return err
+	}
+	return binary.Write(ew.w, binary.BigEndian, ew.crc.Sum32())
+}
+
+// isEnvelope reports whether the buffered stream starts with a snapshot
+// envelope. Snapshots written before the envelope are bare KV lists, accepted
+// only from streams the snapshot store or the backup header marks as legacy.
+func isEnvelope(r *bufio.Reader) bool {
+	head, err := r.Peek(len(snapshotMagic))
+	return err == nil && bytes.Equal(head, snapshotMagic)
+}
+
+// readEnvelopeHeader checks the header of a snapshot envelope and returns its
+// codec and a reader of its body, failing with ErrCorruptSnapshot at the end
+// of the body if the checksum does not match.
+func readEnvelopeHeader(r *bufio.Reader) (byte, io.Reader, error) {
+	header := make([]byte, envelopeHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, fmt.Errorf("error reading snapshot header: %s", err)
+	}
+	if v := header[8]; v != snapshotFormatVersion {
+		return 0, nil, fmt.Errorf("unsupported snapshot format version %d", v)
+	}
+	if v := binary.BigEndian.Uint32(header[9:13]); v > SchemaVersion {
+		return 0, nil, fmt.Errorf("snapshot schema version %d is newer than the supported version %d", v, SchemaVersion)
+	}
+	codec := header[13]
+	if codec != codecNone && codec != codecZstd {
+		return 0, nil, fmt.Errorf("unknown snapshot codec %d", codec)
+	}
+
+	crc := crc32.New(crcTable)
+	crc.Write(header)
+	return codec, &checkedReader{r: r, crc: crc}, nil
+}
+
+// verifyEnvelope reads a whole segment, checking its checksum. Segments
+// without an envelope cannot be checked, and are only accepted from legacy
+// streams.
+func verifyEnvelope(r *bufio.Reader, legacy bool) error {
+	if !isEnvelope(r) {
+		if legacy {
+			return nil
+		}
+		return ErrCorruptSnapshot
+	}
+	_, body, err := readEnvelopeHeader(r)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(ioutil.Discard, body)
+	return err
+}
+
+// openEnvelope returns the decompressed KV lists of a segment.
+func openEnvelope(r *bufio.Reader, legacy bool) (io.ReadCloser, error) {
+	if !isEnvelope(r) {
+		if legacy {
+			return ioutil.NopCloser(r), nil
+		}
+		return nil, ErrCorruptSnapshot
+	}
+	codec, body, err := readEnvelopeHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if codec == codecNone {
+		return ioutil.NopCloser(body), nil
+	}
+	zr, err := zstd.NewReader(body)
+	if err != nil {
+		return nil, err
+	}
+	return zr.IOReadCloser(), nil
+}
+
+// checkedReader reads the body of an envelope, holding back the trailing
+// checksum and comparing it once the end is reached.
+type checkedReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+}
+
+func (c *checkedReader) Read(p []byte) (int, error) {
+	if max := c.r.Size() - checksumSize; len(p) > max {
+		p = p[:max]
+	}
+	buf, err := c.r.Peek(len(p) + checksumSize)
+	if n := len(buf) - checksumSize; n > 0 {
+		n = copy(p, buf[:n])
+		c.crc.Write(p[:n])
+		_, _ = c.r.Discard(n)
+		return n, nil
+	}
+	if err != io.EOF {
+		return 0, err
+	}
+	if len(buf) < checksumSize {
+		return 0, fmt.Errorf("%w: truncated", ErrCorruptSnapshot)
+	}
+	if binary.BigEndian.Uint32(buf) != c.crc.Sum32() {
+		return 0, fmt.Errorf("%w: checksum mismatch", ErrCorruptSnapshot)
+	}
+	return 0, io.EOF
+}
@@ -0,0 +1,51 @@
+package fsm
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ReservedPrefix is the prefix of the keyspace arima keeps for its own replicated
+// bookkeeping. Keys under it are never readable or writable through the store API.
+const ReservedPrefix = "_arima/"
+
+// NamespaceSeparator separates the namespace from the rest of a key, so the key
+// "team-a:users/42" belongs to the namespace "team-a". Keys without a separator
+// belong to the default namespace "".
+const NamespaceSeparator = ':'
+
+var ErrReservedKey = errors.New("key is in the reserved keyspace")
+
+// IsReserved reports whether key lies in the reserved keyspace.
+func IsReserved(key []byte) bool {
+	return bytes.HasPrefix(key, []byte(ReservedPrefix))
+}
+
+// Namespace returns the namespace the key belongs to.
+func Namespace(key []byte) string {
+	i := bytes.IndexByte(key, NamespaceSeparator)
+	if i < 0 {
+		return ""
+	}
+	return string(key[:i])
+}
+
+// NamespacePrefix returns the key prefix covering every key of the namespace.
+func NamespacePrefix(namespace string) string {
+	if namespace == "" {
+		return ""
+	}
+	return namespace + string(NamespaceSeparator)
+}
+
+// reservedKey builds a key inside the reserved keyspace.
+func reservedKey(parts ...string) []byte {
+	key := []byte(ReservedPrefix)
+	for i, part := range parts {
+		if i > 0 {
+			key = append(key, '/')
+		}
+		key = append(key, part...)
+	}
+	return key
+}
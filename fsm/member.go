@@ -0,0 +1,53 @@
+package fsm
+
+import (
+	"github.com/rohankmr414/arima/store"
+)
+
+var memberPrefix = reservedKey("member", "")
+
+func memberKey(nodeID string) []byte {
+	return append(append([]byte{}, memberPrefix...), nodeID...)
+}
+
+// applyMember handles the member_* operations, which record the HTTP address of
+// each raft server so members can reach each other's API.
+func (fsm *ArimaFSM) applyMember(payload CommandPayload) error {
+	return fsm.update(func(txn store.Txn) error {
+		switch payload.Operation {
+		case "member_set":
+			return txn.Set(memberKey(string(payload.Key)), payload.Value)
+		case "member_delete":
+			return txn.Delete(memberKey(string(payload.Key)))
+		}
+		return nil
+	})
+}
+
+// MemberHTTPAddress returns the HTTP address recorded for the node.
+func (fsm *ArimaFSM) MemberHTTPAddress(nodeID string) (string, error) {
+	val, err := fsm.Get(memberKey(nodeID))
+	if err != nil {
+		return "", err
+	}
+	return string(val), nil
+}
+
+// MemberHTTPAddresses returns the HTTP address recorded for every node by node ID.
+func (fsm *ArimaFSM) MemberHTTPAddresses() (map[string]string, error) {
+	addresses := map[string]string{}
+	err := fsm.view(func(txn store.Txn) error {
+		it := txn.NewIterator(store.IteratorOptions{})
+		defer it.Close()
+
+		for it.Seek(memberPrefix); it.ValidForPrefix(memberPrefix); it.Next() {
+			val, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			addresses[string(it.Item().Key()[len(memberPrefix):])] = string(val)
+		}
+		return nil
+	})
+	return addresses, err
+}
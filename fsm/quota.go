@@ -0,0 +1,281 @@
+package fsm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/rohankmr414/arima/store"
+	"github.com/rohankmr414/arima/utils"
+)
+
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+var (
+	quotaLimitPrefix = reservedKey("quota", "limit", "")
+	quotaUsagePrefix = reservedKey("quota", "usage", "")
+	quotaVersionKey  = reservedKey("quota", "version")
+)
+
+// Quota limits of a namespace. A zero limit means unlimited.
+type Quota struct {
+	MaxKeys  uint64 `json:"max_keys"`
+	MaxBytes uint64 `json:"max_bytes"`
+}
+
+// Usage of a namespace. Bytes counts both keys and values.
+type Usage struct {
+	Keys  uint64 `json:"keys"`
+	Bytes uint64 `json:"bytes"`
+}
+
+// NamespaceQuota is the usage of a namespace together with its limits.
+type NamespaceQuota struct {
+	Namespace string `json:"namespace"`
+	Quota     Quota  `json:"quota"`
+	Usage     Usage  `json:"usage"`
+}
+
+func quotaLimitKey(namespace string) []byte {
+	return append(append([]byte{}, quotaLimitPrefix...), namespace...)
+}
+
+func quotaUsageKey(namespace string) []byte {
+	return append(append([]byte{}, quotaUsagePrefix...), namespace...)
+}
+
+// readMsgPack decodes the value of key into out, reporting whether the key exists.
+func readMsgPack(txn store.Txn, key []byte, out interface{}) (bool, error) {
+	item, err := txn.Get(key)
+	if err == store.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	val, err := item.ValueCopy(nil)
+	if err != nil {
+		return false, err
+	}
+	return true, utils.DecodeMsgPack(val, out)
+}
+
+func writeMsgPack(txn store.Txn, key []byte, in interface{}) error {
+	buf, err := utils.EncodeMsgPack(in)
+	if err != nil {
+		return err
+	}
+	return txn.Set(key, buf.Bytes())
+}
+
+// sizeOf returns the number of bytes the key currently accounts for, and whether it exists.
+func sizeOf(txn store.Txn, key []byte) (uint64, bool, error) {
+	item, err := txn.Get(key)
+	if err == store.ErrNotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	var size uint64
+	err = item.Value(func(val []byte) error {
+		size = uint64(len(key) + len(val))
+		return nil
+	})
+	return size, true, err
+}
+
+// setAccounted sets key to value while keeping the usage of its namespace up to date,
+// refusing writes that would grow the namespace past its quota.
+func setAccounted(txn store.Txn, key, value []byte) error {
+	ns := Namespace(key)
+	oldSize, exists, err := sizeOf(txn, key)
+	if err != nil {
+		return err
+	}
+
+	var usage Usage
+	if _, err := readMsgPack(txn, quotaUsageKey(ns), &usage); err != nil {
+		return err
+	}
+	newUsage := usage
+	if !exists {
+		newUsage.Keys++
+	}
+	newUsage.Bytes = usage.Bytes + uint64(len(key)+len(value))
+	if newUsage.Bytes >= oldSize {
+		newUsage.Bytes -= oldSize
+	}
+
+	var quota Quota
+	if _, err := readMsgPack(txn, quotaLimitKey(ns), &quota); err != nil {
+		return err
+	}
+	if quota.MaxKeys > 0 && newUsage.Keys > usage.Keys && newUsage.Keys > quota.MaxKeys {
+		return fmt.Errorf("%w: namespace %q is limited to %d keys", ErrQuotaExceeded, ns, quota.MaxKeys)
+	}
+	if quota.MaxBytes > 0 && newUsage.Bytes > usage.Bytes && newUsage.Bytes > quota.MaxBytes {
+		return fmt.Errorf("%w: namespace %q is limited to %d bytes, write would use %d", ErrQuotaExceeded, ns, quota.MaxBytes, newUsage.Bytes)
+	}
+
+	if err := txn.Set(key, value); err != nil {
+		return err
+	}
+	return writeMsgPack(txn, quotaUsageKey(ns), newUsage)
+}
+
+// deleteAccounted deletes key and releases its share of the namespace usage.
+func deleteAccounted(txn store.Txn, key []byte) error {
+	size, exists, err := sizeOf(txn, key)
+	if err != nil || !exists {
+		return err
+	}
+	ns := Namespace(key)
+	var usage Usage
+	if _, err := readMsgPack(txn, quotaUsageKey(ns), &usage); err != nil {
+		return err
+	}
+	if usage.Keys > 0 {
+		usage.Keys--
+	}
+	if usage.Bytes >= size {
+		usage.Bytes -= size
+	} else {
+		usage.Bytes = 0
+	}
+	if err := txn.Delete(key); err != nil {
+		return err
+	}
+	return writeMsgPack(txn, quotaUsageKey(ns), usage)
+}
+
+// applyQuota handles the quota_set and quota_delete operations.
+func (fsm *ArimaFSM) applyQuota(payload CommandPayload) error {
+	ns := string(payload.Key)
+	return fsm.update(func(txn store.Txn) error {
+		if payload.Operation == "quota_delete" {
+			return txn.Delete(quotaLimitKey(ns))
+		}
+		var quota Quota
+		if err := utils.DecodeMsgPack(payload.Value, &quota); err != nil {
+			return err
+		}
+		return writeMsgPack(txn, quotaLimitKey(ns), quota)
+	})
+}
+
+// Quota returns the limits and usage of a namespace.
+func (fsm *ArimaFSM) Quota(namespace string) (NamespaceQuota, error) {
+	nq := NamespaceQuota{Namespace: namespace}
+	err := fsm.view(func(txn store.Txn) error {
+		if _, err := readMsgPack(txn, quotaLimitKey(namespace), &nq.Quota); err != nil {
+			return err
+		}
+		_, err := readMsgPack(txn, quotaUsageKey(namespace), &nq.Usage)
+		return err
+	})
+	return nq, err
+}
+
+// Quotas returns the limits and usage of every namespace that has either.
+func (fsm *ArimaFSM) Quotas() ([]NamespaceQuota, error) {
+	byNamespace := map[string]*NamespaceQuota{}
+	entry := func(ns string) *NamespaceQuota {
+		if _, ok := byNamespace[ns]; !ok {
+			byNamespace[ns] = &NamespaceQuota{Namespace: ns}
+		}
+		return byNamespace[ns]
+	}
+
+	err := fsm.view(func(txn store.Txn) error {
+		it := txn.NewIterator(store.IteratorOptions{})
+		defer it.Close()
+
+		for it.Seek(quotaLimitPrefix); it.ValidForPrefix(quotaLimitPrefix); it.Next() {
+			nq := entry(string(it.Item().Key()[len(quotaLimitPrefix):]))
+			if err := it.Item().Value(func(val []byte) error {
+				return utils.DecodeMsgPack(val, &nq.Quota)
+			}); err != nil {
+				return err
+			}
+		}
+		for it.Seek(quotaUsagePrefix); it.ValidForPrefix(quotaUsagePrefix); it.Next() {
+			nq := entry(string(it.Item().Key()[len(quotaUsagePrefix):]))
+			if err := it.Item().Value(func(val []byte) error {
+				return utils.DecodeMsgPack(val, &nq.Usage)
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	quotas := make([]NamespaceQuota, 0, len(byNamespace))
+	for _, nq := range byNamespace {
+		quotas = append(quotas, *nq)
+	}
+	sort.Slice(quotas, func(i, j int) bool {
+		return quotas[i].Namespace < quotas[j].Namespace
+	})
+	return quotas, nil
+}
+
+// rebuildUsage recounts the usage of every namespace from the stored keys. The result
+// only depends on the data, so every node computes the same usage.
+func rebuildUsage(db store.Engine) error {
+	usage := map[string]*Usage{}
+	err := db.View(func(txn store.Txn) error {
+		it := txn.NewIterator(store.IteratorOptions{})
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			if IsReserved(item.Key()) {
+				continue
+			}
+			ns := Namespace(item.Key())
+			if _, ok := usage[ns]; !ok {
+				usage[ns] = &Usage{}
+			}
+			err := item.Value(func(val []byte) error {
+				usage[ns].Keys++
+				usage[ns].Bytes += uint64(len(item.Key()) + len(val))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error counting namespace usage: %s", err)
+	}
+
+	if err := db.DropPrefix(quotaUsagePrefix); err != nil {
+		return err
+	}
+	return db.Update(func(txn store.Txn) error {
+		for ns, u := range usage {
+			if err := writeMsgPack(txn, quotaUsageKey(ns), u); err != nil {
+				return err
+			}
+		}
+		return txn.Set(quotaVersionKey, utils.Uint64ToBytes(1))
+	})
+}
+
+// ensureUsage builds the usage counters for data written before they were tracked.
+func ensureUsage(db store.Engine) error {
+	err := db.View(func(txn store.Txn) error {
+		_, err := txn.Get(quotaVersionKey)
+		return err
+	})
+	if err == store.ErrNotFound {
+		return rebuildUsage(db)
+	}
+	return err
+}
@@ -0,0 +1,47 @@
+package fsm
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+// progressInterval is how often the progress of a restore is logged.
+const progressInterval = 10 * time.Second
+
+// progressReader logs how much of a snapshot was loaded.
+type progressReader struct {
+	r      io.Reader
+	total  int64
+	read   int64
+	logged time.Time
+}
+
+func newProgressReader(r io.Reader, total int64) *progressReader {
+	return &progressReader{r: r, total: total, logged: time.Now()}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	if time.Since(p.logged) >= progressInterval && p.total > 0 {
+		log.Printf("Restoring snapshot: %d%% (%s of %s)",
+			p.read*100/p.total, formatBytes(p.read), formatBytes(p.total))
+		p.logged = time.Now()
+	}
+	return n, err
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,157 @@
+package fsm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"github.com/rohankmr414/arima/store"
+	"github.com/rohankmr414/arima/utils"
+)
+
+func TestRestoreRefusesCorruptSnapshots(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(snap []byte) []byte
+	}{
+		{"bad magic", func(snap []byte) []byte {
+			snap[0] ^= 0xff
+			return snap
+		}},
+		{"unknown format version", func(snap []byte) []byte {
+			snap[8] = snapshotFormatVersion + 1
+			return snap
+		}},
+		{"newer schema version", func(snap []byte) []byte {
+			binary.BigEndian.PutUint32(snap[9:13], SchemaVersion+1)
+			return snap
+		}},
+		{"unknown codec", func(snap []byte) []byte {
+			snap[13] = 0x7f
+			return snap
+		}},
+		{"flipped checksum", func(snap []byte) []byte {
+			snap[len(snap)-1] ^= 0xff
+			return snap
+		}},
+		{"truncated", func(snap []byte) []byte {
+			return snap[:len(snap)-2]
+		}},
+	}
+	for _, engine := range []string{"memory", "badger"} {
+		for _, codec := range []string{"none", "zstd"} {
+			for _, tt := range tests {
+				t.Run(engine+"/"+codec+"/"+tt.name, func(t *testing.T) {
+					fsm := newTestFSM(t, engine, codec)
+					apply(t, fsm, "set", "key", "snapshot")
+					snap := snapshot(t, fsm)
+					apply(t, fsm, "set", "key", "live")
+					apply(t, fsm, "set", "other", "live")
+
+					snap = tt.corrupt(snap)
+					if err := fsm.Restore(ioutil.NopCloser(bytes.NewReader(snap))); err == nil {
+						t.Fatal("restored a corrupt snapshot")
+					}
+					requireValue(t, fsm, "key", "live")
+					requireValue(t, fsm, "other", "live")
+
+					// The FSM still takes commands after the refused restore.
+					apply(t, fsm, "set", "key", "after")
+					requireValue(t, fsm, "key", "after")
+				})
+			}
+		}
+	}
+}
+
+func TestRestoreReplacesState(t *testing.T) {
+	fsm := newTestFSM(t, "badger", "zstd")
+	apply(t, fsm, "set", "key", "snapshot")
+	snap := snapshot(t, fsm)
+	apply(t, fsm, "set", "key", "live")
+	apply(t, fsm, "set", "other", "live")
+
+	if err := fsm.Restore(ioutil.NopCloser(bytes.NewReader(snap))); err != nil {
+		t.Fatalf("error restoring snapshot: %s", err)
+	}
+	requireValue(t, fsm, "key", "snapshot")
+	if _, err := fsm.Get([]byte("other")); err == nil {
+		t.Fatal("other survived the restore of a snapshot without it")
+	}
+}
+
+func newTestFSM(t *testing.T, engine, codec string) *ArimaFSM {
+	t.Helper()
+	opts := store.Options{SnapshotCompression: codec}
+	var (
+		fsm *ArimaFSM
+		err error
+	)
+	if engine == "memory" {
+		fsm, err = NewArimaFSMWithStore(store.NewMemoryFSMStore(), opts)
+	} else {
+		fsm, err = NewArimaFSM(t.TempDir(), opts)
+	}
+	if err != nil {
+		t.Fatalf("error opening fsm: %s", err)
+	}
+	t.Cleanup(func() { fsm.Close() })
+	return fsm
+}
+
+func apply(t *testing.T, fsm *ArimaFSM, op, key, value string) {
+	t.Helper()
+	data, err := utils.EncodeMsgPack(CommandPayload{Operation: op, Key: []byte(key), Value: []byte(value)})
+	if err != nil {
+		t.Fatalf("error encoding command: %s", err)
+	}
+	resp := fsm.Apply(&raft.Log{Index: 1, Term: 1, Type: raft.LogCommand, Data: data.Bytes()})
+	if r, ok := resp.(*ApplyResponse); !ok || r.Error != nil {
+		t.Fatalf("error applying %s %s: %v", op, key, resp)
+	}
+}
+
+// snapshot returns a full snapshot of fsm, as raft would hand it to Restore.
+func snapshot(t *testing.T, fsm *ArimaFSM) []byte {
+	t.Helper()
+	snap, err := fsm.Snapshot()
+	if err != nil {
+		t.Fatalf("error taking snapshot: %s", err)
+	}
+	snaps := raft.NewInmemSnapshotStore()
+	sink, err := snaps.Create(raft.SnapshotVersionMax, 1, 1, raft.Configuration{}, 1, nil)
+	if err != nil {
+		t.Fatalf("error creating snapshot: %s", err)
+	}
+	if err := snap.Persist(sink); err != nil {
+		t.Fatalf("error persisting snapshot: %s", err)
+	}
+	snap.Release()
+
+	_, r, err := snaps.Open(sink.ID())
+	if err != nil {
+		t.Fatalf("error opening snapshot: %s", err)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("error reading snapshot: %s", err)
+	}
+	if !bytes.HasPrefix(data, snapshotMagic) {
+		t.Fatalf("snapshot does not start with an envelope")
+	}
+	return data
+}
+
+func requireValue(t *testing.T, fsm *ArimaFSM, key, want string) {
+	t.Helper()
+	got, err := fsm.Get([]byte(key))
+	if err != nil {
+		t.Fatalf("error reading %s: %s", key, err)
+	}
+	if string(got) != want {
+		t.Fatalf("%s is %q, want %q", key, got, want)
+	}
+}
@@ -1,23 +1,83 @@
 package fsm
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"log"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
 
-	"github.com/dgraph-io/badger/v3"
 	"github.com/hashicorp/raft"
+	"github.com/rohankmr414/arima/encryption"
+	"github.com/rohankmr414/arima/store"
 )
 
 type ArimaSnapshot struct {
-	Conn *badger.DB
+	keyring *encryption.Keyring
+	codec   byte
+
+	fsm *ArimaFSM
+	// txn pins the state of the store when the snapshot was taken.
+	txn      store.Snapshot
+	restores uint64
+	// parentID is the snapshot an incremental snapshot builds on, empty for a
+	// full one. after is the version of the parent: only keys changed since
+	// are persisted.
+	parentID string
+	after    uint64
+	// length is the number of snapshots in the chain, this one included.
+	length int
+
+	// done releases the database once, after Persist or on Release. Raft's
+	// RecoverCluster persists a snapshot without releasing it.
+	done sync.Once
+}
+
+// snapshotChain is the last snapshot persisted by the FSM.
+type snapshotChain struct {
+	id      string
+	length  int
+	version uint64
+	// txn is the transaction the snapshot was read with. Keeping it open stops
+	// the engine from dropping the deletions made since, for example badger
+	// from compacting away its deletion markers, which the next incremental
+	// snapshot has to persist.
+	txn store.Snapshot
+}
+
+// reset forgets the chain, so the next snapshot is a full one.
+func (c *snapshotChain) reset() {
+	if c.txn != nil {
+		c.txn.Discard()
+	}
+	*c = snapshotChain{}
 }
 
 // Persist should dump all necessary state to the WriteCloser 'sink',
 // and call sink.Close() when finished or call sink.Cancel() on error.
 func (snap *ArimaSnapshot) Persist(sink raft.SnapshotSink) error {
 	log.Println("Persisting snapshot")
-	_, err := snap.Conn.Backup(sink, 0)
+	defer metrics.MeasureSince([]string{"fsm", "snapshot", "persist"}, time.Now())
+	id := ""
+	defer func() {
+		snap.finish(id)
+	}()
+
+	// The header of incremental snapshots stays in the clear, so the snapshot
+	// store can follow the chain without the encryption key.
+	if snap.parentID != "" {
+		if err := store.WriteDeltaHeader(sink, snap.parentID); err != nil {
+			_ = sink.Cancel()
+			return fmt.Errorf("error persisting snapshot: %s", err)
+		}
+	}
+
+	err := writeState(sink, snap.keyring, snap.codec, snap.txn, snap.after)
 	if err != nil {
+		_ = sink.Cancel()
 		return fmt.Errorf("error persisting snapshot: %s", err)
 	}
 
@@ -25,10 +85,98 @@ func (snap *ArimaSnapshot) Persist(sink raft.SnapshotSink) error {
 	if err != nil {
 		return fmt.Errorf("error closing snapshot: %s", err)
 	}
+	id = sink.ID()
 	return nil
 }
 
 // Release is invoked when we are finished with the snapshot.
 func (snap *ArimaSnapshot) Release() {
 	log.Println("Releasing snapshot")
+	snap.finish("")
+}
+
+// finish releases the database held by the snapshot. A snapshot persisted as
+// id becomes the base of the next incremental one.
+func (snap *ArimaSnapshot) finish(id string) {
+	snap.done.Do(func() {
+		fsm := snap.fsm
+		defer fsm.dbMu.RUnlock()
+		fsm.mu.Lock()
+		defer fsm.mu.Unlock()
+		if id == "" || snap.restores != fsm.restores {
+			snap.txn.Discard()
+			return
+		}
+		fsm.chain.reset()
+		fsm.chain = snapshotChain{
+			id:      id,
+			length:  snap.length,
+			version: snap.txn.Version(),
+			txn:     snap.txn,
+		}
+	})
+}
+
+// writeState writes the keys of txn changed after the given version, all of
+// them when zero, in a snapshot envelope. The file snapshot store and backups
+// are written outside badger, so the state is encrypted here with the same key
+// as the data at rest.
+func writeState(w io.Writer, keyring *encryption.Keyring, codec byte, txn store.Snapshot, after uint64) error {
+	var dst io.WriteCloser = nopCloser{w}
+	if keyring != nil {
+		ew, err := encryption.NewWriter(w, keyring.Current())
+		if err != nil {
+			return fmt.Errorf("error encrypting snapshot: %s", err)
+		}
+		dst = ew
+	}
+
+	env, err := newEnvelopeWriter(dst, codec)
+	if err != nil {
+		return err
+	}
+	if err := dump(txn, env, after); err != nil {
+		return err
+	}
+	if err := env.Close(); err != nil {
+		return err
+	}
+	return dst.Close()
+}
+
+// nopCloser lets the unencrypted sink be closed separately from the snapshot stream.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// ReencryptSnapshot copies a snapshot from r to w, decrypting it with from and
+// encrypting it with the key to. Either may be nil for unencrypted snapshots.
+func ReencryptSnapshot(r io.Reader, w io.Writer, from *encryption.Keyring, to []byte) error {
+	var (
+		src io.Reader = bufio.NewReader(r)
+		err error
+	)
+	if encryption.IsEncrypted(src.(*bufio.Reader)) {
+		src, err = encryption.NewReader(src, from)
+		if err != nil {
+			return err
+		}
+	}
+
+	var dst io.WriteCloser = nopCloser{w}
+	if len(to) > 0 {
+		dst, err = encryption.NewWriter(w, to)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return err
+	}
+	return dst.Close()
 }
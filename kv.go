@@ -0,0 +1,95 @@
+package arima
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/raft"
+	"github.com/rohankmr414/arima/fsm"
+	"github.com/rohankmr414/arima/store"
+	"github.com/rohankmr414/arima/utils"
+)
+
+var (
+	// ErrNotLeader is returned by writes and membership changes made on a node
+	// that is not the leader.
+	ErrNotLeader = raft.ErrNotLeader
+	// ErrNotFound is returned by Get for a key that is not set.
+	ErrNotFound = store.ErrNotFound
+	// ErrEmptyKey is returned for an empty key.
+	ErrEmptyKey = errors.New("key is empty")
+)
+
+// Set sets key to value. It must be called on the leader and returns once the
+// write is applied there, or ctx is done; a write given up on may still be
+// applied.
+func (n *Node) Set(ctx context.Context, key, value []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	return n.apply(ctx, fsm.CommandPayload{
+		Operation: "set",
+		Key:       key,
+		Value:     value,
+	})
+}
+
+// Delete deletes key. It must be called on the leader, like Set.
+func (n *Node) Delete(ctx context.Context, key []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	return n.apply(ctx, fsm.CommandPayload{
+		Operation: "delete",
+		Key:       key,
+	})
+}
+
+// Get reads key from the state of this node, which may trail the leader. It can
+// be called on any node, making reads eventually consistent.
+func (n *Node) Get(key []byte) ([]byte, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if _, err := n.running(); err != nil {
+		return nil, err
+	}
+	return n.stores.FSM.Get(key)
+}
+
+func checkKey(key []byte) error {
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+	if fsm.IsReserved(key) {
+		return fsm.ErrReservedKey
+	}
+	return nil
+}
+
+// apply replicates the payload and returns the error reported by the FSM.
+func (n *Node) apply(ctx context.Context, payload fsm.CommandPayload) error {
+	r, err := n.leader()
+	if err != nil {
+		return err
+	}
+
+	data, err := utils.EncodeMsgPack(payload)
+	if err != nil {
+		return fmt.Errorf("error preparing payload: %s", err)
+	}
+
+	applyFuture := r.Apply(data.Bytes(), 0)
+	if err := waitFuture(ctx, applyFuture); err != nil {
+		return err
+	}
+
+	resp, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	if !ok {
+		return fmt.Errorf("error response is not match apply response")
+	}
+	return resp.Error
+}
@@ -0,0 +1,111 @@
+package arima
+
+import (
+	"github.com/hashicorp/raft"
+	"github.com/rohankmr414/arima/cluster"
+)
+
+// Raft returns the raft instance of the node, for what the node does not wrap.
+// It is nil unless the node is running.
+func (n *Node) Raft() *raft.Raft {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	r, _ := n.running()
+	return r
+}
+
+// leader returns the raft instance of the node, failing unless it is running
+// and leads.
+func (n *Node) leader() (*raft.Raft, error) {
+	r := n.Raft()
+	if r == nil {
+		return nil, ErrNotStarted
+	}
+	if r.State() != raft.Leader {
+		return nil, ErrNotLeader
+	}
+	return r, nil
+}
+
+// IsLeader reports whether the node is the leader.
+func (n *Node) IsLeader() bool {
+	r := n.Raft()
+	return r != nil && r.State() == raft.Leader
+}
+
+// Leader returns the raft address and node ID of the leader, both empty when no
+// leader is known.
+func (n *Node) Leader() (address, nodeID string) {
+	r := n.Raft()
+	if r == nil {
+		return "", ""
+	}
+	leader := r.Leader()
+	if leader == "" {
+		return "", ""
+	}
+	future := r.GetConfiguration()
+	if err := future.Error(); err == nil {
+		for _, server := range future.Configuration().Servers {
+			if server.Address == leader {
+				return string(leader), string(server.ID)
+			}
+		}
+	}
+	return string(leader), ""
+}
+
+// Members lists the servers of the cluster. On the leader it includes the
+// replication state of every member.
+func (n *Node) Members() ([]cluster.Member, error) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if _, err := n.running(); err != nil {
+		return nil, err
+	}
+	return n.monitor.Members()
+}
+
+// Join adds a node to the cluster, as a non-voter when nonVoter is set, and
+// records the URL of its HTTP API when httpAddress is set. It must be called on
+// the leader; a node that is already a member is left as it is.
+func (n *Node) Join(nodeID, raftAddress, httpAddress string, nonVoter bool) error {
+	r, err := n.leader()
+	if err != nil {
+		return err
+	}
+	if _, err := cluster.AddServer(r, nodeID, raftAddress, nonVoter); err != nil {
+		return err
+	}
+	if httpAddress == "" {
+		return nil
+	}
+	return cluster.RecordHTTPAddress(r, nodeID, httpAddress)
+}
+
+// Remove removes a node from the cluster. It must be called on the leader.
+// Unlike the HTTP API, it does not check the voters left can form a quorum.
+func (n *Node) Remove(nodeID string) error {
+	r, err := n.leader()
+	if err != nil {
+		return err
+	}
+	configFuture := r.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return err
+	}
+	member := false
+	for _, server := range configFuture.Configuration().Servers {
+		member = member || server.ID == raft.ServerID(nodeID)
+	}
+	if !member {
+		return nil
+	}
+	n.mu.RLock()
+	stores := n.stores
+	n.mu.RUnlock()
+	if stores == nil {
+		return ErrNotStarted
+	}
+	return cluster.RemoveServer(r, stores.FSM, nodeID, configFuture.Index())
+}
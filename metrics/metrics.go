@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	gometrics "github.com/armon/go-metrics"
+	gometricsprom "github.com/armon/go-metrics/prometheus"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Setup routes everything emitted through go-metrics, which hashicorp/raft and the
+// arima stores use, to the default prometheus registry.
+func Setup() error {
+	sink, err := gometricsprom.NewPrometheusSinkFrom(gometricsprom.PrometheusOpts{
+		// go-metrics only reports values updated within the expiration.
+		Expiration: time.Minute,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating prometheus sink: %s", err)
+	}
+	// The sink registers itself with the default registry.
+
+	conf := gometrics.DefaultConfig("arima")
+	conf.EnableHostname = false
+	conf.EnableHostnameLabel = false
+	if _, err := gometrics.NewGlobal(conf, sink); err != nil {
+		return fmt.Errorf("error setting up metrics: %s", err)
+	}
+	return nil
+}
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "arima",
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "arima",
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+)
+
+func init() {
+	prometheus.MustRegister(requestsTotal, requestDuration)
+}
+
+// Middleware records the count and latency of every request by route.
+func Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(eCtx echo.Context) error {
+		start := time.Now()
+		err := next(eCtx)
+		if err != nil {
+			eCtx.Error(err)
+		}
+
+		route := eCtx.Path()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := eCtx.Request().Method
+		requestsTotal.WithLabelValues(route, method, strconv.Itoa(eCtx.Response().Status)).Inc()
+		requestDuration.WithLabelValues(route, method).Observe(time.Since(start).Seconds())
+		return nil
+	}
+}
+
+// BadgerDB is a badger database, or a store whose database may be replaced.
+type BadgerDB interface {
+	Size() (lsm, vlog int64)
+	IsClosed() bool
+}
+
+// badgerCollector reports the on-disk size of badger databases.
+type badgerCollector struct {
+	dbs      map[string]BadgerDB
+	lsmSize  *prometheus.Desc
+	vlogSize *prometheus.Desc
+}
+
+// RegisterBadger reports the LSM and value log sizes of each database, labelled
+// by its name in dbs.
+func RegisterBadger(dbs map[string]BadgerDB) error {
+	return prometheus.Register(&badgerCollector{
+		dbs: dbs,
+		lsmSize: prometheus.NewDesc("arima_badger_lsm_size_bytes",
+			"Size of the badger LSM tree.", []string{"store"}, nil),
+		vlogSize: prometheus.NewDesc("arima_badger_vlog_size_bytes",
+			"Size of the badger value log.", []string{"store"}, nil),
+	})
+}
+
+func (c *badgerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.lsmSize
+	ch <- c.vlogSize
+}
+
+func (c *badgerCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, db := range c.dbs {
+		if db.IsClosed() {
+			continue
+		}
+		lsm, vlog := db.Size()
+		ch <- prometheus.MustNewConstMetric(c.lsmSize, prometheus.GaugeValue, float64(lsm), name)
+		ch <- prometheus.MustNewConstMetric(c.vlogSize, prometheus.GaugeValue, float64(vlog), name)
+	}
+}
@@ -0,0 +1,434 @@
+// Package arima embeds an arima node in a Go program: a key-value store
+// replicated with raft, optionally serving the HTTP API the arima binary serves.
+package arima
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/rohankmr414/arima/autopilot"
+	"github.com/rohankmr414/arima/cluster"
+	"github.com/rohankmr414/arima/metrics"
+	"github.com/rohankmr414/arima/server"
+	"github.com/rohankmr414/arima/store"
+	"github.com/rohankmr414/arima/tlsutil"
+	arimatransport "github.com/rohankmr414/arima/transport"
+)
+
+// ErrNotStarted is returned by a node that was not started, or was shut down.
+var ErrNotStarted = errors.New("node is not started")
+
+// httpServer is the HTTP API of a node.
+type httpServer interface {
+	Start() error
+	Shutdown(ctx context.Context) error
+	Close() error
+}
+
+// Node is a member of an arima cluster. Unless bootstrapped with the servers of
+// a new cluster, every node starts out as the leader of a cluster of its own,
+// which other nodes join through Join on its leader or through the HTTP API.
+type Node struct {
+	opts Options
+
+	// mu is held for writing while the node starts and stops, and for reading
+	// by the methods that use raft or the stores.
+	mu      sync.RWMutex
+	stopped bool
+
+	raft      *raft.Raft
+	stores    *Stores
+	snapshots raft.SnapshotStore
+	// transport is closed on shutdown when the node created it.
+	transport    raft.Transport
+	ownTransport bool
+	monitor      *cluster.Monitor
+	pilot        *autopilot.Autopilot
+	srv          httpServer
+	stopWatch    chan struct{}
+	errs         chan error
+}
+
+// New returns a node configured with opts, which does nothing until started.
+func New(opts Options) (*Node, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if opts.Autopilot.Interval == 0 {
+		opts.Autopilot = DefaultAutopilot
+	}
+	if opts.LogOutput == nil {
+		opts.LogOutput = os.Stdout
+	}
+	return &Node{opts: opts, errs: make(chan error, 1)}, nil
+}
+
+// Start opens the stores, starts raft and serves the HTTP API when configured.
+// It gives up, releasing what it opened, once ctx is done. A node can only be
+// started once; a node with the same options takes the place of a stopped one.
+func (n *Node) Start(ctx context.Context) (err error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.raft != nil || n.stopped {
+		return errors.New("node was already started")
+	}
+	defer func() {
+		if err != nil {
+			n.release()
+		}
+	}()
+
+	opts := n.opts
+	storeOpts := opts.Storage.Options
+	if opts.Storage.InMemory {
+		// The in-memory snapshot store only keeps the latest snapshot, which
+		// therefore has to be a full one.
+		log.Println("Keeping all data in memory, it is lost when the node stops")
+		storeOpts.FullSnapshotInterval = 1
+		mem := opts.MemoryStores
+		if mem == nil {
+			mem = NewMemoryStores()
+		}
+		n.stores, err = openInMemoryStores(storeOpts, mem)
+		n.snapshots = mem.Snapshots
+	} else {
+		n.stores, err = OpenStores(opts.VolumeDir, opts.Storage)
+		if err == nil {
+			n.snapshots, err = store.NewSnapshotStore(opts.VolumeDir, SnapshotRetain, storeOpts.FullSnapshotInterval, opts.LogOutput)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if opts.Metrics {
+		if err := metrics.RegisterBadger(n.stores.databases); err != nil {
+			return err
+		}
+	}
+
+	var certs *tlsutil.Reloader
+	if opts.TLS.HTTP || opts.TLS.Raft {
+		certs, err = tlsutil.NewReloader(opts.TLS.CertFile, opts.TLS.KeyFile, opts.TLS.CAFile)
+		if err != nil {
+			return fmt.Errorf("error loading certificates: %s", err)
+		}
+		n.stopWatch = make(chan struct{})
+		go certs.Watch(tlsReloadInterval, n.stopWatch)
+	}
+
+	var tlsStream *arimatransport.TLSStreamLayer
+	n.transport = opts.Transport
+	if n.transport == nil {
+		tlsStream, err = n.newTransport(certs)
+		if err != nil {
+			return err
+		}
+	}
+
+	logStore := n.stores.Log
+	if opts.Storage.LogCacheSize > 0 && !opts.Storage.InMemory {
+		logStore, err = store.NewLogCache(opts.Storage.LogCacheSize, n.stores.Log)
+		if err != nil {
+			return err
+		}
+	}
+
+	raftConf := raft.DefaultConfig()
+	if opts.Raft != nil {
+		conf := *opts.Raft
+		raftConf = &conf
+	}
+	raftConf.LocalID = raft.ServerID(opts.NodeID)
+
+	n.raft, err = raft.NewRaft(raftConf, n.stores.FSM, logStore, n.stores.Stable, n.snapshots, n.transport)
+	if err != nil {
+		return err
+	}
+
+	// always start single server as a leader, unless bootstrapping a cluster
+	configuration := raft.Configuration{
+		Servers: []raft.Server{
+			{
+				ID:      raftConf.LocalID,
+				Address: n.transport.LocalAddr(),
+			},
+		},
+	}
+	if len(opts.Bootstrap) > 0 {
+		configuration.Servers = opts.Bootstrap
+	}
+
+	n.raft.BootstrapCluster(configuration)
+
+	if tlsStream != nil {
+		go refreshTLSPeers(n.raft, tlsStream)
+	}
+
+	var httpTLS, peerTLS *tls.Config
+	if opts.TLS.HTTP {
+		httpTLS = certs.ServerConfig(false)
+		peerTLS = certs.ClientConfig("")
+	}
+
+	n.monitor = cluster.NewMonitor(n.raft, n.stores.FSM, peerTLS)
+	n.pilot = autopilot.New(n.raft, n.monitor, opts.Autopilot)
+	go n.pilot.Run()
+
+	if opts.HTTPAddress == "" {
+		return nil
+	}
+
+	advertiseURL := opts.AdvertiseURL
+	if advertiseURL == "" {
+		advertiseURL, err = defaultAdvertiseURL(opts.HTTPAddress, opts.TLS.HTTP)
+		if err != nil {
+			return err
+		}
+	}
+	go cluster.AdvertiseHTTPAddress(n.raft, n.stores.FSM, opts.NodeID, advertiseURL)
+
+	n.srv = server.New(opts.HTTPAddress, n.stores.FSM, n.raft, server.Options{
+		ACLEnabled:     opts.ACLEnabled,
+		ACLMasterToken: opts.ACLMasterToken,
+		TLSConfig:      httpTLS,
+		Stores:         n.stores.pingers,
+		Monitor:        n.monitor,
+		SnapshotStore:  n.snapshots,
+		Autopilot:      n.pilot,
+		ReadyMaxLag:    opts.ReadyMaxLag,
+	})
+	go func(srv httpServer) {
+		if err := srv.Start(); err != http.ErrServerClosed {
+			n.errs <- err
+		}
+	}(n.srv)
+	return nil
+}
+
+// newTransport creates the TCP transport of the node, or the TLS one with raft
+// TLS enabled, in which case it returns its stream layer.
+func (n *Node) newTransport(certs *tlsutil.Reloader) (*arimatransport.TLSStreamLayer, error) {
+	raftBindAddr := n.opts.RaftAddress
+	tcpAddr, err := net.ResolveTCPAddr("tcp", raftBindAddr)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving TCP address: %s", err)
+	}
+
+	var (
+		tlsStream     *arimatransport.TLSStreamLayer
+		transportKind = "TCP"
+	)
+	if n.opts.TLS.Raft {
+		transportKind = "TLS"
+		tlsStream, err = arimatransport.NewTLSStreamLayer(raftBindAddr, tcpAddr, certs)
+		if err == nil {
+			n.transport = raft.NewNetworkTransport(tlsStream, maxPool, tcpTimeout, n.opts.LogOutput)
+		}
+	} else {
+		n.transport, err = raft.NewTCPTransport(raftBindAddr, tcpAddr, maxPool, tcpTimeout, n.opts.LogOutput)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s transport: %s", transportKind, err)
+	}
+	n.ownTransport = true
+	return tlsStream, nil
+}
+
+// defaultAdvertiseURL returns the URL of the HTTP API on the loopback address.
+func defaultAdvertiseURL(httpAddress string, https bool) (string, error) {
+	_, port, err := net.SplitHostPort(httpAddress)
+	if err != nil {
+		return "", fmt.Errorf("error parsing HTTP address: %s", err)
+	}
+	scheme := "http"
+	if https {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://127.0.0.1:%s", scheme, port), nil
+}
+
+// release stops what a failed start opened.
+func (n *Node) release() {
+	if n.raft != nil {
+		if err := n.raft.Shutdown().Error(); err != nil {
+			log.Printf("error shutting down raft: %s", err)
+		}
+	}
+	if n.stores != nil {
+		if err := n.stores.Close(); err != nil {
+			log.Printf("error closing store: %s", err)
+		}
+	}
+	if n.monitor != nil {
+		n.monitor.Close()
+	}
+	n.closeTransport()
+	if n.stopWatch != nil {
+		close(n.stopWatch)
+	}
+	n.raft, n.stores, n.monitor, n.pilot, n.srv, n.stopWatch = nil, nil, nil, nil, nil, nil
+}
+
+func (n *Node) closeTransport() {
+	if !n.ownTransport {
+		return
+	}
+	if closer, ok := n.transport.(raft.WithClose); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("error closing transport: %s", err)
+		}
+	}
+	n.ownTransport = false
+}
+
+// Err receives the error the HTTP API stopped serving with, such as its address
+// being in use.
+func (n *Node) Err() <-chan error {
+	return n.errs
+}
+
+// running returns the raft instance of the node, or ErrNotStarted unless it was
+// started and has not been stopped. n.mu must be held.
+func (n *Node) running() (*raft.Raft, error) {
+	if n.raft == nil || n.stopped {
+		return nil, ErrNotStarted
+	}
+	return n.raft, nil
+}
+
+// Shutdown drains in-flight HTTP requests, hands leadership to another voter,
+// shuts raft down and closes the stores. The steps share ctx; the stores are left
+// open if raft has not stopped by the time ctx is done, since it may still use them.
+func (n *Node) Shutdown(ctx context.Context) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.raft == nil || n.stopped {
+		return nil
+	}
+	n.stopped = true
+
+	if n.srv != nil {
+		if err := n.srv.Shutdown(ctx); err != nil {
+			log.Printf("error draining HTTP requests: %s", err)
+		}
+	}
+
+	r := n.raft
+	if r.State() == raft.Leader && hasOtherVoter(r) {
+		log.Println("Transferring leadership")
+		if err := waitFuture(ctx, r.LeadershipTransfer()); err != nil {
+			log.Printf("error transferring leadership: %s", err)
+		} else {
+			log.Println("Transferred leadership")
+		}
+	}
+
+	if err := waitFuture(ctx, r.Shutdown()); err != nil {
+		return fmt.Errorf("error shutting down raft: %s", err)
+	}
+	if err := n.closeStopped(); err != nil {
+		return err
+	}
+	log.Println("Shut down cleanly")
+	return nil
+}
+
+// Stop stops the node the way a crash would: the HTTP API stops serving at once,
+// failing in-flight requests, and raft is shut down without handing leadership
+// over. Like Shutdown, it closes the stores.
+func (n *Node) Stop() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.raft == nil || n.stopped {
+		return nil
+	}
+	n.stopped = true
+
+	if n.srv != nil {
+		if err := n.srv.Close(); err != nil {
+			log.Printf("error closing HTTP server: %s", err)
+		}
+	}
+	if err := n.raft.Shutdown().Error(); err != nil {
+		return fmt.Errorf("error shutting down raft: %s", err)
+	}
+	return n.closeStopped()
+}
+
+// closeStopped releases what a stopped node holds once raft is shut down.
+func (n *Node) closeStopped() error {
+	n.monitor.Close()
+	n.closeTransport()
+	if n.stopWatch != nil {
+		close(n.stopWatch)
+		n.stopWatch = nil
+	}
+
+	var closeErr error
+	for _, s := range n.stores.closers {
+		if err := s.Close(); err != nil {
+			log.Printf("error closing store: %s", err)
+			closeErr = err
+		}
+	}
+	n.raft, n.stores, n.snapshots, n.monitor, n.pilot, n.srv = nil, nil, nil, nil, nil, nil
+	return closeErr
+}
+
+// hasOtherVoter reports whether leadership can be handed to another server.
+func hasOtherVoter(r *raft.Raft) bool {
+	future := r.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return false
+	}
+	for _, server := range future.Configuration().Servers {
+		if server.Suffrage == raft.Voter && server.Address != r.Leader() {
+			return true
+		}
+	}
+	return false
+}
+
+// waitFuture waits for a raft future until ctx is done.
+func waitFuture(ctx context.Context, future raft.Future) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- future.Error()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// refreshTLSPeers keeps the members the TLS stream layer verifies peers against
+// in sync with the raft configuration, starting right away so a restarted node
+// does not accept unknown peers until the first tick.
+func refreshTLSPeers(r *raft.Raft, stream *arimatransport.TLSStreamLayer) {
+	ticker := time.NewTicker(tlsPeerRefreshInterval)
+	defer ticker.Stop()
+	for {
+		future := r.GetConfiguration()
+		if err := future.Error(); err == raft.ErrRaftShutdown {
+			return
+		} else if err == nil {
+			stream.UpdatePeers(future.Configuration())
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,156 @@
+package arima
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/rohankmr414/arima/autopilot"
+	"github.com/rohankmr414/arima/store"
+)
+
+// SnapshotRetain is how many snapshots are kept on disk. Must be at least 1.
+const SnapshotRetain = 2
+
+const (
+	// The maxPool controls how many connections we will pool.
+	maxPool = 3
+
+	// The timeout is used to apply I/O deadlines. For InstallSnapshot, we multiply
+	// the timeout by (SnapshotSize / TimeoutScale).
+	tcpTimeout = 10 * time.Second
+
+	// How often certificate files are checked for rotation.
+	tlsReloadInterval = 10 * time.Second
+
+	// How often the raft TLS stream layer refreshes the node IDs it verifies peers against.
+	tlsPeerRefreshInterval = time.Second
+)
+
+// DefaultAutopilot is the autopilot configuration used when none is given.
+var DefaultAutopilot = autopilot.Config{
+	Interval:             2 * time.Second,
+	LastContactThreshold: time.Second,
+	MaxLag:               250,
+	StabilizationTime:    10 * time.Second,
+}
+
+// Options configures a node.
+type Options struct {
+	// NodeID is the raft ID of the node, unique within the cluster.
+	NodeID string
+	// RaftAddress is the host:port raft listens on and other nodes reach this
+	// node at.
+	RaftAddress string
+	// Transport carries the raft traffic instead of a TCP transport listening
+	// on RaftAddress, for example a raft.InmemTransport. The node leaves it
+	// open when it shuts down.
+	Transport raft.Transport
+	// Raft tunes raft, raft.DefaultConfig() when nil. LocalID is set to NodeID.
+	Raft *raft.Config
+	// Bootstrap are the servers a new cluster is formed with, given to every
+	// one of them. When empty, the node bootstraps a cluster of its own, which
+	// other nodes join.
+	Bootstrap []raft.Server
+
+	// HTTPAddress is the address the HTTP API listens on, such as ":2222". The
+	// API is not served when it is empty.
+	HTTPAddress string
+	// AdvertiseURL is the URL other nodes reach the HTTP API of this node at,
+	// http(s)://127.0.0.1:<port of HTTPAddress> when empty.
+	AdvertiseURL string
+	// ReadyMaxLag is how many log entries the node may have left to apply and
+	// still report ready.
+	ReadyMaxLag uint64
+	// ACLEnabled requires a bearer token on every request to the HTTP API.
+	ACLEnabled bool
+	// ACLMasterToken is always granted full privileges, used to bootstrap ACLs.
+	ACLMasterToken string
+	TLS            TLSOptions
+
+	// VolumeDir is the directory the data is kept in, required unless
+	// Storage.InMemory is set.
+	VolumeDir string
+	Storage   StorageOptions
+	// MemoryStores are the raft stores of an in-memory node, new ones when nil.
+	MemoryStores *MemoryStores
+
+	// Autopilot manages the membership on the leader, DefaultAutopilot when
+	// its Interval is zero.
+	Autopilot autopilot.Config
+	// Metrics reports the sizes of the badger databases to the default
+	// prometheus registry. Only one node of a process can report them.
+	Metrics bool
+	// LogOutput receives the logs of the raft transport and snapshot store,
+	// os.Stdout when nil.
+	LogOutput io.Writer
+}
+
+// TLSOptions configures TLS on the HTTP API and mutual TLS between raft peers.
+type TLSOptions struct {
+	// CertFile is the certificate of this node, issued to its node ID for raft
+	// mutual TLS.
+	CertFile string
+	KeyFile  string
+	// CAFile is the CA bundle raft peers are verified with.
+	CAFile string
+	HTTP   bool
+	Raft   bool
+}
+
+// StorageOptions configures where and how the data of a node is kept.
+type StorageOptions struct {
+	store.Options
+	// LogCacheSize is the number of recent raft log entries kept in memory, 0
+	// disables the cache.
+	LogCacheSize int
+	// UnifiedStorage keeps the raft log, the stable store and the FSM in a
+	// single badger database, migrating a volume that uses separate ones.
+	UnifiedStorage bool
+	// Engine is the engine the FSM keeps its state in, "badger" or "memory".
+	// Empty means badger.
+	Engine string
+	// InMemory keeps the FSM, the raft log and the snapshots in memory.
+	// Nothing survives a restart.
+	InMemory bool
+}
+
+func (o StorageOptions) validate() error {
+	switch o.Engine {
+	case "", "badger", "memory":
+	default:
+		return fmt.Errorf("unknown storage engine %q, expected badger or memory", o.Engine)
+	}
+	return nil
+}
+
+func (o Options) validate() error {
+	if o.NodeID == "" {
+		return errors.New("node ID is required")
+	}
+	if o.Transport == nil && o.RaftAddress == "" {
+		return errors.New("raft address is required unless a transport is given")
+	}
+	if o.VolumeDir == "" && !o.Storage.InMemory {
+		return errors.New("volume dir is required unless in-memory storage is set")
+	}
+	if len(o.Bootstrap) > 0 && !bootstraps(o.Bootstrap, o.NodeID) {
+		return fmt.Errorf("node %s is not one of the bootstrap servers", o.NodeID)
+	}
+	if o.TLS.Raft && o.Transport != nil {
+		return errors.New("raft TLS cannot be combined with a transport of its own")
+	}
+	return o.Storage.validate()
+}
+
+// bootstraps reports whether nodeID is one of servers.
+func bootstraps(servers []raft.Server, nodeID string) bool {
+	for _, server := range servers {
+		if server.ID == raft.ServerID(nodeID) {
+			return true
+		}
+	}
+	return false
+}
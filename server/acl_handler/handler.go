@@ -0,0 +1,46 @@
+package acl_handler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/rohankmr414/arima/fsm"
+	"github.com/rohankmr414/arima/utils"
+)
+
+// handler struct handler
+type handler struct {
+	raft *raft.Raft
+	fsm  *fsm.ArimaFSM
+}
+
+func New(raft *raft.Raft, fsm *fsm.ArimaFSM) *handler {
+	return &handler{
+		raft: raft,
+		fsm:  fsm,
+	}
+}
+
+// apply replicates the payload and returns the error reported by the FSM.
+func (h handler) apply(payload fsm.CommandPayload) error {
+	if h.raft.State() != raft.Leader {
+		return fmt.Errorf("not the leader")
+	}
+
+	data, err := utils.EncodeMsgPack(payload)
+	if err != nil {
+		return err
+	}
+
+	applyFuture := h.raft.Apply(data.Bytes(), 500*time.Millisecond)
+	if err := applyFuture.Error(); err != nil {
+		return err
+	}
+
+	resp, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	if !ok {
+		return fmt.Errorf("error response is not match apply response")
+	}
+	return resp.Error
+}
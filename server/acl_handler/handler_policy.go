@@ -0,0 +1,102 @@
+package acl_handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rohankmr414/arima/acl"
+	"github.com/rohankmr414/arima/fsm"
+	"github.com/rohankmr414/arima/utils"
+)
+
+// SetPolicy creates or replaces a policy. Must be done in raft leader, otherwise return error.
+func (h handler) SetPolicy(eCtx echo.Context) error {
+	policy := acl.Policy{}
+	if err := eCtx.Bind(&policy); err != nil {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("error binding: %s", err.Error()),
+		})
+	}
+
+	if err := policy.Validate(); err != nil {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("invalid policy: %s", err.Error()),
+		})
+	}
+
+	data, err := utils.EncodeMsgPack(policy)
+	if err != nil {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("error preparing policy: %s", err.Error()),
+		})
+	}
+
+	payload := fsm.CommandPayload{
+		Operation: "acl_policy_set",
+		Key:       []byte(policy.Name),
+		Value:     data.Bytes(),
+	}
+	if err := h.apply(payload); err != nil {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("error saving policy in raft cluster: %s", err.Error()),
+		})
+	}
+
+	return eCtx.JSON(http.StatusOK, map[string]interface{}{
+		"message": "success saving policy",
+		"data":    policy,
+	})
+}
+
+// GetPolicy returns a single policy.
+func (h handler) GetPolicy(eCtx echo.Context) error {
+	name := eCtx.Param("name")
+	policy, err := h.fsm.ACLPolicy(name)
+	if err != nil {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("error getting policy %s: %s", name, err.Error()),
+		})
+	}
+
+	return eCtx.JSON(http.StatusOK, map[string]interface{}{
+		"message": "success fetching policy",
+		"data":    policy,
+	})
+}
+
+// ListPolicies returns every policy.
+func (h handler) ListPolicies(eCtx echo.Context) error {
+	policies, err := h.fsm.ACLPolicies()
+	if err != nil {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("error listing policies: %s", err.Error()),
+		})
+	}
+
+	return eCtx.JSON(http.StatusOK, map[string]interface{}{
+		"message": "success fetching policies",
+		"data":    policies,
+	})
+}
+
+// DeletePolicy removes a policy. Tokens referring to it lose its rules.
+func (h handler) DeletePolicy(eCtx echo.Context) error {
+	name := eCtx.Param("name")
+	payload := fsm.CommandPayload{
+		Operation: "acl_policy_delete",
+		Key:       []byte(name),
+	}
+	if err := h.apply(payload); err != nil {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("error removing policy in raft cluster: %s", err.Error()),
+		})
+	}
+
+	return eCtx.JSON(http.StatusOK, map[string]interface{}{
+		"message": "success removing policy",
+		"data": map[string]interface{}{
+			"name": name,
+		},
+	})
+}
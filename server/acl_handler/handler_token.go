@@ -0,0 +1,137 @@
+package acl_handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rohankmr414/arima/acl"
+	"github.com/rohankmr414/arima/fsm"
+	"github.com/rohankmr414/arima/utils"
+)
+
+// requestToken request payload for creating or updating a token
+type requestToken struct {
+	AccessorID  string   `json:"accessor_id"`
+	Description string   `json:"description"`
+	Policies    []string `json:"policies"`
+}
+
+// SetToken creates a token, or updates the description and policies of an existing one
+// when accessor_id is given. The secret of a new token is only returned by this call.
+// Must be done in raft leader, otherwise return error.
+func (h handler) SetToken(eCtx echo.Context) error {
+	form := requestToken{}
+	if err := eCtx.Bind(&form); err != nil {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("error binding: %s", err.Error()),
+		})
+	}
+
+	token := acl.Token{
+		AccessorID:  form.AccessorID,
+		Description: form.Description,
+		Policies:    form.Policies,
+	}
+
+	var secret string
+	if token.AccessorID == "" {
+		var err error
+		if token.AccessorID, err = acl.GenerateID(); err == nil {
+			secret, err = acl.GenerateID()
+		}
+		if err != nil {
+			return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		token.SecretHash = acl.HashSecret(secret)
+	} else if _, err := h.fsm.ACLToken(token.AccessorID); err != nil {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("error getting token %s: %s", token.AccessorID, err.Error()),
+		})
+	}
+
+	data, err := utils.EncodeMsgPack(token)
+	if err != nil {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("error preparing token: %s", err.Error()),
+		})
+	}
+
+	payload := fsm.CommandPayload{
+		Operation: "acl_token_set",
+		Key:       []byte(token.AccessorID),
+		Value:     data.Bytes(),
+	}
+	if err := h.apply(payload); err != nil {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("error saving token in raft cluster: %s", err.Error()),
+		})
+	}
+
+	resp := map[string]interface{}{
+		"accessor_id": token.AccessorID,
+		"description": token.Description,
+		"policies":    token.Policies,
+	}
+	if secret != "" {
+		resp["secret_id"] = secret
+	}
+	return eCtx.JSON(http.StatusOK, map[string]interface{}{
+		"message": "success saving token",
+		"data":    resp,
+	})
+}
+
+// GetToken returns a single token, without its secret.
+func (h handler) GetToken(eCtx echo.Context) error {
+	accessorID := eCtx.Param("accessor_id")
+	token, err := h.fsm.ACLToken(accessorID)
+	if err != nil {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("error getting token %s: %s", accessorID, err.Error()),
+		})
+	}
+
+	return eCtx.JSON(http.StatusOK, map[string]interface{}{
+		"message": "success fetching token",
+		"data":    token,
+	})
+}
+
+// ListTokens returns every token, without their secrets.
+func (h handler) ListTokens(eCtx echo.Context) error {
+	tokens, err := h.fsm.ACLTokens()
+	if err != nil {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("error listing tokens: %s", err.Error()),
+		})
+	}
+
+	return eCtx.JSON(http.StatusOK, map[string]interface{}{
+		"message": "success fetching tokens",
+		"data":    tokens,
+	})
+}
+
+// DeleteToken revokes a token.
+func (h handler) DeleteToken(eCtx echo.Context) error {
+	accessorID := eCtx.Param("accessor_id")
+	payload := fsm.CommandPayload{
+		Operation: "acl_token_delete",
+		Key:       []byte(accessorID),
+	}
+	if err := h.apply(payload); err != nil {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("error removing token in raft cluster: %s", err.Error()),
+		})
+	}
+
+	return eCtx.JSON(http.StatusOK, map[string]interface{}{
+		"message": "success removing token",
+		"data": map[string]interface{}{
+			"accessor_id": accessorID,
+		},
+	})
+}
@@ -0,0 +1,21 @@
+package admin_handler
+
+import (
+	"github.com/hashicorp/raft"
+	"github.com/rohankmr414/arima/fsm"
+)
+
+// handler struct handler
+type handler struct {
+	raft      *raft.Raft
+	fsm       *fsm.ArimaFSM
+	snapshots raft.SnapshotStore
+}
+
+func New(raft *raft.Raft, fsm *fsm.ArimaFSM, snapshots raft.SnapshotStore) *handler {
+	return &handler{
+		raft:      raft,
+		fsm:       fsm,
+		snapshots: snapshots,
+	}
+}
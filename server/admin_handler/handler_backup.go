@@ -0,0 +1,59 @@
+package admin_handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rohankmr414/arima/backup"
+	"github.com/rohankmr414/arima/server/deadline"
+)
+
+// Backup streams a consistent dump of the FSM, tagged with the raft index and term
+// of the last command it includes.
+func (h handler) Backup(eCtx echo.Context) error {
+	view, err := h.fsm.PointInTime()
+	if err != nil {
+		return eCtx.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": fmt.Sprintf("error writing backup: %s", err.Error()),
+		})
+	}
+	defer view.Release()
+
+	header := backup.Header{
+		Index:     view.Index,
+		Term:      view.Term,
+		CreatedAt: time.Now().UTC(),
+		Envelope:  true,
+	}
+	if header.Index == 0 {
+		// No command was applied since the FSM was restored, so it holds
+		// exactly the last snapshot.
+		stats := h.raft.Stats()
+		header.Index, _ = strconv.ParseUint(stats["last_snapshot_index"], 10, 64)
+		header.Term, _ = strconv.ParseUint(stats["last_snapshot_term"], 10, 64)
+	}
+
+	resp := eCtx.Response()
+	resp.Header().Set(echo.HeaderContentType, echo.MIMEOctetStream)
+	resp.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=arima-%d-%d.bak", header.Term, header.Index))
+	resp.Header().Set("X-Arima-Index", strconv.FormatUint(header.Index, 10))
+	resp.Header().Set("X-Arima-Term", strconv.FormatUint(header.Term, 10))
+	resp.WriteHeader(http.StatusOK)
+
+	w := deadline.Writer(eCtx.Request(), resp)
+	if err := backup.WriteHeader(w, header); err != nil {
+		log.Printf("error writing backup: %s", err)
+		panic(http.ErrAbortHandler)
+	}
+	if err := view.Write(w); err != nil {
+		// The status is already sent, so the connection is aborted for the
+		// client to see the backup is incomplete.
+		log.Printf("error writing backup: %s", err)
+		panic(http.ErrAbortHandler)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package admin_handler
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/hashicorp/raft"
+	"github.com/labstack/echo/v4"
+	"github.com/rohankmr414/arima/server/deadline"
+)
+
+// snapshotServer is a server of the configuration stored in a snapshot
+type snapshotServer struct {
+	NodeID      string `json:"node_id"`
+	RaftAddress string `json:"raft_address"`
+	Suffrage    string `json:"suffrage"`
+}
+
+// snapshotInfo is the metadata of a snapshot
+type snapshotInfo struct {
+	ID                 string               `json:"id"`
+	Index              uint64               `json:"index"`
+	Term               uint64               `json:"term"`
+	Size               int64                `json:"size"`
+	Version            raft.SnapshotVersion `json:"version"`
+	ConfigurationIndex uint64               `json:"configuration_index"`
+	Configuration      []snapshotServer     `json:"configuration"`
+}
+
+func newSnapshotInfo(meta *raft.SnapshotMeta) snapshotInfo {
+	info := snapshotInfo{
+		ID:                 meta.ID,
+		Index:              meta.Index,
+		Term:               meta.Term,
+		Size:               meta.Size,
+		Version:            meta.Version,
+		ConfigurationIndex: meta.ConfigurationIndex,
+		Configuration:      []snapshotServer{},
+	}
+	for _, server := range meta.Configuration.Servers {
+		info.Configuration = append(info.Configuration, snapshotServer{
+			NodeID:      string(server.ID),
+			RaftAddress: string(server.Address),
+			Suffrage:    server.Suffrage.String(),
+		})
+	}
+	return info
+}
+
+// TakeSnapshot snapshots the FSM of this node and compacts its log
+func (h handler) TakeSnapshot(eCtx echo.Context) error {
+	future := h.raft.Snapshot()
+	if err := future.Error(); err != nil {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("error taking snapshot: %s", err.Error()),
+		})
+	}
+
+	meta, source, err := future.Open()
+	if err != nil {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("error opening snapshot: %s", err.Error()),
+		})
+	}
+	source.Close()
+
+	return eCtx.JSON(http.StatusOK, map[string]interface{}{
+		"message": fmt.Sprintf("snapshot %s taken", meta.ID),
+		"data":    newSnapshotInfo(meta),
+	})
+}
+
+// ListSnapshots lists the snapshots retained by this node, newest first
+func (h handler) ListSnapshots(eCtx echo.Context) error {
+	metas, err := h.snapshots.List()
+	if err != nil {
+		return eCtx.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": fmt.Sprintf("error listing snapshots: %s", err.Error()),
+		})
+	}
+
+	snapshots := make([]snapshotInfo, 0, len(metas))
+	for _, meta := range metas {
+		snapshots = append(snapshots, newSnapshotInfo(meta))
+	}
+	return eCtx.JSON(http.StatusOK, map[string]interface{}{
+		"message": "success fetching snapshots",
+		"data":    snapshots,
+	})
+}
+
+// DownloadSnapshot streams the state of a snapshot as the snapshot store holds it,
+// encrypted when encryption at rest is enabled.
+func (h handler) DownloadSnapshot(eCtx echo.Context) error {
+	id := eCtx.Param("id")
+	metas, err := h.snapshots.List()
+	if err != nil {
+		return eCtx.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": fmt.Sprintf("error listing snapshots: %s", err.Error()),
+		})
+	}
+	found := false
+	for _, meta := range metas {
+		found = found || meta.ID == id
+	}
+	if !found {
+		return eCtx.JSON(http.StatusNotFound, map[string]interface{}{
+			"error": fmt.Sprintf("snapshot %s not found", id),
+		})
+	}
+
+	meta, source, err := h.snapshots.Open(id)
+	if err != nil {
+		return eCtx.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": fmt.Sprintf("error opening snapshot: %s", err.Error()),
+		})
+	}
+
+	resp := eCtx.Response()
+	resp.Header().Set(echo.HeaderContentType, echo.MIMEOctetStream)
+	resp.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%s.snap", meta.ID))
+	resp.Header().Set(echo.HeaderContentLength, strconv.FormatInt(meta.Size, 10))
+	resp.Header().Set("X-Arima-Index", strconv.FormatUint(meta.Index, 10))
+	resp.Header().Set("X-Arima-Term", strconv.FormatUint(meta.Term, 10))
+	resp.WriteHeader(http.StatusOK)
+
+	_, err = io.Copy(deadline.Writer(eCtx.Request(), resp), source)
+	// The file snapshot store verifies the checksum once the state is read.
+	if closeErr := source.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Printf("error streaming snapshot %s: %s", id, err)
+		panic(http.ErrAbortHandler)
+	}
+	return nil
+}
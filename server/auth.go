@@ -0,0 +1,76 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rohankmr414/arima/acl"
+	"github.com/rohankmr414/arima/fsm"
+	"github.com/rohankmr414/arima/store"
+)
+
+// authenticate resolves the bearer token of every request into an authorizer. When
+// ACLs are disabled every request is allowed everything. Health checks need no token.
+func authenticate(opts Options, arimaFsm *fsm.ArimaFSM) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(eCtx echo.Context) error {
+			if strings.HasPrefix(eCtx.Path(), "/health/") {
+				return next(eCtx)
+			}
+			if !opts.ACLEnabled {
+				acl.WithAuthorizer(eCtx, acl.AllowAll)
+				return next(eCtx)
+			}
+
+			secret := bearerToken(eCtx.Request())
+			if secret == "" {
+				return eCtx.JSON(http.StatusUnauthorized, map[string]interface{}{
+					"error": "missing bearer token",
+				})
+			}
+
+			if opts.ACLMasterToken != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(opts.ACLMasterToken)) == 1 {
+				acl.WithAuthorizer(eCtx, acl.AllowAll)
+				return next(eCtx)
+			}
+
+			_, policies, err := arimaFsm.ResolveACLToken(secret)
+			if err == store.ErrNotFound {
+				return eCtx.JSON(http.StatusForbidden, map[string]interface{}{
+					"error": "token not found",
+				})
+			}
+			if err != nil {
+				return eCtx.JSON(http.StatusInternalServerError, map[string]interface{}{
+					"error": "error resolving token: " + err.Error(),
+				})
+			}
+
+			acl.WithAuthorizer(eCtx, acl.NewAuthorizer(policies))
+			return next(eCtx)
+		}
+	}
+}
+
+// requireClusterAdmin guards the endpoints that manage the cluster itself.
+func requireClusterAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(eCtx echo.Context) error {
+		if !acl.FromContext(eCtx).ClusterAdmin() {
+			return eCtx.JSON(http.StatusForbidden, map[string]interface{}{
+				"error": "permission denied: cluster admin required",
+			})
+		}
+		return next(eCtx)
+	}
+}
+
+// bearerToken extracts the token from the Authorization header.
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get(echo.HeaderAuthorization)
+	if len(header) > len("Bearer ") && strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(header[len("Bearer "):])
+	}
+	return ""
+}
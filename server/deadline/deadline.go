@@ -0,0 +1,43 @@
+package deadline
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+type connKey struct{}
+
+// ConnContext records the connection of every request, for http.Server.ConnContext.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// streamTimeout is how long a single write of a streamed body may block.
+const streamTimeout = 10 * time.Second
+
+// Writer returns w with the write deadline of the connection pushed past every
+// write, for handlers that stream large bodies. The server's timeouts would cut
+// such bodies off, while no deadline at all would let a client that stops
+// reading hold the handler forever. The read deadline is lifted. The server sets
+// both again for the next request on the connection.
+func Writer(r *http.Request, w io.Writer) io.Writer {
+	c, ok := r.Context().Value(connKey{}).(net.Conn)
+	if !ok {
+		return w
+	}
+	_ = c.SetReadDeadline(time.Time{})
+	return &deadlineWriter{w: w, conn: c}
+}
+
+type deadlineWriter struct {
+	w    io.Writer
+	conn net.Conn
+}
+
+func (d *deadlineWriter) Write(p []byte) (int, error) {
+	_ = d.conn.SetWriteDeadline(time.Now().Add(streamTimeout))
+	return d.w.Write(p)
+}
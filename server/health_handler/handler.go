@@ -0,0 +1,28 @@
+package health_handler
+
+import (
+	"github.com/hashicorp/raft"
+)
+
+// Pinger is a store whose health is part of the node readiness.
+type Pinger interface {
+	// Ping returns an error when the store is closed or cannot be written.
+	Ping() error
+}
+
+// handler struct handler
+type handler struct {
+	raft   *raft.Raft
+	stores map[string]Pinger
+	maxLag uint64
+}
+
+// New returns the health handler. A node is only ready while its applied index is
+// at most maxLag entries behind its commit index.
+func New(raft *raft.Raft, stores map[string]Pinger, maxLag uint64) *handler {
+	return &handler{
+		raft:   raft,
+		stores: stores,
+		maxLag: maxLag,
+	}
+}
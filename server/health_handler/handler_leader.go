@@ -0,0 +1,21 @@
+package health_handler
+
+import (
+	"net/http"
+
+	"github.com/hashicorp/raft"
+	"github.com/labstack/echo/v4"
+)
+
+// Leader returns 200 only on the leader, so load balancers can route writes to it.
+func (h handler) Leader(eCtx echo.Context) error {
+	if h.raft.State() != raft.Leader {
+		return eCtx.JSON(http.StatusServiceUnavailable, map[string]interface{}{
+			"error":  "not the leader",
+			"leader": string(h.raft.Leader()),
+		})
+	}
+	return eCtx.JSON(http.StatusOK, map[string]interface{}{
+		"message": "leader",
+	})
+}
@@ -0,0 +1,14 @@
+package health_handler
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Live reports the process is up and serving requests.
+func (h handler) Live(eCtx echo.Context) error {
+	return eCtx.JSON(http.StatusOK, map[string]interface{}{
+		"message": "alive",
+	})
+}
@@ -0,0 +1,74 @@
+package health_handler
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Ready reports whether the node can serve requests: it knows a leader, it has
+// applied the log close to its commit index, and its stores are open and can sync.
+func (h handler) Ready(eCtx echo.Context) error {
+	var failures []string
+
+	leader := h.raft.Leader()
+	if leader == "" {
+		failures = append(failures, "no known leader")
+	}
+
+	stats := h.raft.Stats()
+	lastLogIndex, _ := strconv.ParseUint(stats["last_log_index"], 10, 64)
+	commitIndex, err := strconv.ParseUint(stats["commit_index"], 10, 64)
+	if err != nil {
+		failures = append(failures, fmt.Sprintf("error reading commit index: %s", err.Error()))
+	}
+	appliedIndex := h.raft.AppliedIndex()
+	var lag uint64
+	if commitIndex > appliedIndex {
+		lag = commitIndex - appliedIndex
+	}
+	if lag > h.maxLag {
+		failures = append(failures, fmt.Sprintf("applied index %d is %d entries behind commit index %d", appliedIndex, lag, commitIndex))
+	}
+
+	names := make([]string, 0, len(h.stores))
+	for name := range h.stores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	stores := make(map[string]string, len(h.stores))
+	for _, name := range names {
+		if err := h.stores[name].Ping(); err != nil {
+			stores[name] = err.Error()
+			failures = append(failures, fmt.Sprintf("%s store: %s", name, err.Error()))
+			continue
+		}
+		stores[name] = "ok"
+	}
+
+	data := map[string]interface{}{
+		"leader":         string(leader),
+		"last_contact":   stats["last_contact"],
+		"last_log_index": lastLogIndex,
+		"state":          h.raft.State().String(),
+		"commit_index":   commitIndex,
+		"applied_index":  appliedIndex,
+		"lag":            lag,
+		"stores":         stores,
+	}
+
+	if len(failures) > 0 {
+		return eCtx.JSON(http.StatusServiceUnavailable, map[string]interface{}{
+			"error":  "not ready",
+			"data":   data,
+			"checks": failures,
+		})
+	}
+	return eCtx.JSON(http.StatusOK, map[string]interface{}{
+		"message": "ready",
+		"data":    data,
+	})
+}
@@ -0,0 +1,19 @@
+package quota_handler
+
+import (
+	"github.com/hashicorp/raft"
+	"github.com/rohankmr414/arima/fsm"
+)
+
+// handler struct handler
+type handler struct {
+	raft *raft.Raft
+	fsm  *fsm.ArimaFSM
+}
+
+func New(raft *raft.Raft, fsm *fsm.ArimaFSM) *handler {
+	return &handler{
+		raft: raft,
+		fsm:  fsm,
+	}
+}
@@ -0,0 +1,48 @@
+package quota_handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/hashicorp/raft"
+	"github.com/labstack/echo/v4"
+	"github.com/rohankmr414/arima/acl"
+	"github.com/rohankmr414/arima/fsm"
+)
+
+// Delete removes the quota of a namespace, leaving it unlimited. Usage keeps being tracked.
+// Delete must be done in raft leader, otherwise return error.
+func (h handler) Delete(eCtx echo.Context) error {
+	namespace := eCtx.Param("namespace")
+
+	if !acl.FromContext(eCtx).KeyAdmin(fsm.NamespacePrefix(namespace)) {
+		return eCtx.JSON(http.StatusForbidden, map[string]interface{}{
+			"error": fmt.Sprintf("permission denied: no admin access to namespace %s", namespace),
+		})
+	}
+
+	if h.raft.State() != raft.Leader {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": "not the leader",
+		})
+	}
+
+	payload := fsm.CommandPayload{
+		Operation: "quota_delete",
+		Key:       []byte(namespace),
+		Value:     nil,
+	}
+
+	if err := h.apply(payload); err != nil {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("error removing quota in raft cluster: %s", err.Error()),
+		})
+	}
+
+	return eCtx.JSON(http.StatusOK, map[string]interface{}{
+		"message": "success removing quota",
+		"data": map[string]interface{}{
+			"namespace": namespace,
+		},
+	})
+}
@@ -0,0 +1,56 @@
+package quota_handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rohankmr414/arima/acl"
+	"github.com/rohankmr414/arima/fsm"
+)
+
+// List returns the limits and usage of every namespace, as seen by this node.
+func (h handler) List(eCtx echo.Context) error {
+	quotas, err := h.fsm.Quotas()
+	if err != nil {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("error reading quotas: %s", err.Error()),
+		})
+	}
+
+	authz := acl.FromContext(eCtx)
+	visible := quotas[:0]
+	for _, quota := range quotas {
+		if authz.KeyRead(fsm.NamespacePrefix(quota.Namespace)) {
+			visible = append(visible, quota)
+		}
+	}
+
+	return eCtx.JSON(http.StatusOK, map[string]interface{}{
+		"message": "success fetching quotas",
+		"data":    visible,
+	})
+}
+
+// Get returns the limits and usage of a single namespace, as seen by this node.
+func (h handler) Get(eCtx echo.Context) error {
+	namespace := eCtx.Param("namespace")
+
+	if !acl.FromContext(eCtx).KeyRead(fsm.NamespacePrefix(namespace)) {
+		return eCtx.JSON(http.StatusForbidden, map[string]interface{}{
+			"error": fmt.Sprintf("permission denied: no read access to namespace %s", namespace),
+		})
+	}
+
+	quota, err := h.fsm.Quota(namespace)
+	if err != nil {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("error reading quota of namespace %s: %s", namespace, err.Error()),
+		})
+	}
+
+	return eCtx.JSON(http.StatusOK, map[string]interface{}{
+		"message": "success fetching quota",
+		"data":    quota,
+	})
+}
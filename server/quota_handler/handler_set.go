@@ -0,0 +1,89 @@
+package quota_handler
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/labstack/echo/v4"
+	"github.com/rohankmr414/arima/acl"
+	"github.com/rohankmr414/arima/fsm"
+	"github.com/rohankmr414/arima/utils"
+)
+
+// requestSet request payload for setting the quota of a namespace
+type requestSet struct {
+	Namespace string `json:"namespace"`
+	MaxKeys   uint64 `json:"max_keys"`
+	MaxBytes  uint64 `json:"max_bytes"`
+}
+
+// Set replaces the quota of a namespace. The quota is replicated through raft so every
+// node enforces the same limits. Set must be done in raft leader, otherwise return error.
+func (h handler) Set(eCtx echo.Context) error {
+	form := requestSet{}
+	if err := eCtx.Bind(&form); err != nil {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("error binding: %s", err.Error()),
+		})
+	}
+
+	if !acl.FromContext(eCtx).KeyAdmin(fsm.NamespacePrefix(form.Namespace)) {
+		return eCtx.JSON(http.StatusForbidden, map[string]interface{}{
+			"error": fmt.Sprintf("permission denied: no admin access to namespace %s", form.Namespace),
+		})
+	}
+
+	if h.raft.State() != raft.Leader {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": "not the leader",
+		})
+	}
+
+	quota, err := utils.EncodeMsgPack(fsm.Quota{
+		MaxKeys:  form.MaxKeys,
+		MaxBytes: form.MaxBytes,
+	})
+	if err != nil {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("error preparing quota: %s", err.Error()),
+		})
+	}
+
+	payload := fsm.CommandPayload{
+		Operation: "quota_set",
+		Key:       []byte(form.Namespace),
+		Value:     quota.Bytes(),
+	}
+
+	if err := h.apply(payload); err != nil {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("error setting quota in raft cluster: %s", err.Error()),
+		})
+	}
+
+	return eCtx.JSON(http.StatusOK, map[string]interface{}{
+		"message": "success setting quota",
+		"data":    form,
+	})
+}
+
+// apply replicates the payload and returns the error reported by the FSM.
+func (h handler) apply(payload fsm.CommandPayload) error {
+	data, err := utils.EncodeMsgPack(payload)
+	if err != nil {
+		return err
+	}
+
+	applyFuture := h.raft.Apply(data.Bytes(), 500*time.Millisecond)
+	if err := applyFuture.Error(); err != nil {
+		return err
+	}
+
+	resp, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	if !ok {
+		return fmt.Errorf("error response is not match apply response")
+	}
+	return resp.Error
+}
@@ -1,16 +1,48 @@
 package raft_handler
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/hashicorp/raft"
+	"github.com/rohankmr414/arima/autopilot"
+	"github.com/rohankmr414/arima/cluster"
+	"github.com/rohankmr414/arima/fsm"
+	"github.com/rohankmr414/arima/utils"
 )
 
 // handler struct handler
 type handler struct {
-	raft *raft.Raft
+	raft      *raft.Raft
+	fsm       *fsm.ArimaFSM
+	monitor   *cluster.Monitor
+	autopilot *autopilot.Autopilot
 }
 
-func New(raft *raft.Raft) *handler {
+func New(raft *raft.Raft, fsm *fsm.ArimaFSM, monitor *cluster.Monitor, autopilot *autopilot.Autopilot) *handler {
 	return &handler{
-		raft: raft,
+		raft:      raft,
+		fsm:       fsm,
+		monitor:   monitor,
+		autopilot: autopilot,
+	}
+}
+
+// apply replicates the payload and returns the error reported by the FSM.
+func (h handler) apply(payload fsm.CommandPayload) error {
+	data, err := utils.EncodeMsgPack(payload)
+	if err != nil {
+		return err
+	}
+
+	applyFuture := h.raft.Apply(data.Bytes(), 500*time.Millisecond)
+	if err := applyFuture.Error(); err != nil {
+		return err
+	}
+
+	resp, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	if !ok {
+		return fmt.Errorf("error response is not match apply response")
 	}
+	return resp.Error
 }
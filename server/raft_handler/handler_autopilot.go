@@ -0,0 +1,43 @@
+package raft_handler
+
+import (
+	"net/http"
+
+	"github.com/hashicorp/raft"
+	"github.com/labstack/echo/v4"
+	"github.com/rohankmr414/arima/autopilot"
+)
+
+// AutopilotRaftHandler reports the health autopilot tracks for every server
+func (h handler) AutopilotRaftHandler(eCtx echo.Context) error {
+	if h.autopilot == nil {
+		return eCtx.JSON(http.StatusNotFound, map[string]interface{}{
+			"error": "autopilot is not running",
+		})
+	}
+	if h.raft.State() != raft.Leader {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": "not the leader",
+		})
+	}
+
+	health := h.autopilot.Health()
+	healthy := true
+	for _, server := range health {
+		healthy = healthy && server.Healthy
+	}
+	return eCtx.JSON(http.StatusOK, map[string]interface{}{
+		"message": "success fetching server health",
+		"data": map[string]interface{}{
+			"healthy": healthy,
+			"servers": nonNil(health),
+		},
+	})
+}
+
+func nonNil(health []autopilot.ServerHealth) []autopilot.ServerHealth {
+	if health == nil {
+		return []autopilot.ServerHealth{}
+	}
+	return health
+}
@@ -6,12 +6,19 @@ import (
 
 	"github.com/hashicorp/raft"
 	"github.com/labstack/echo/v4"
+	"github.com/rohankmr414/arima/cluster"
+	"github.com/rohankmr414/arima/fsm"
 )
 
 // requestJoin request payload for joining raft cluster
 type requestJoin struct {
 	NodeID      string `json:"node_id"`
 	RaftAddress string `json:"raft_address"`
+	// HTTPAddress is the URL of the node's API, such as http://127.0.0.1:2222.
+	HTTPAddress string `json:"http_address"`
+	// NonVoter joins the node as a read replica, which receives the log but
+	// does not count towards quorum.
+	NonVoter bool `json:"non_voter"`
 }
 
 // JoinRaftHandler handling join raft
@@ -28,29 +35,45 @@ func (h handler) JoinRaftHandler(eCtx echo.Context) error {
 		raftAddr = form.RaftAddress
 	)
 
-	if h.raft.State() != raft.Leader {
+	if nodeID == "" || raftAddr == "" {
 		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
-			"error": "not the leader",
+			"error": "node_id and raft_address are required",
 		})
 	}
 
-	configFuture := h.raft.GetConfiguration()
-	if err := configFuture.Error(); err != nil {
+	if h.raft.State() != raft.Leader {
 		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
-			"error": fmt.Sprintf("failed to get raft configuration: %s", err.Error()),
+			"error": "not the leader",
 		})
 	}
 
 	// This must be run on the leader or it will fail.
-	f := h.raft.AddVoter(raft.ServerID(nodeID), raft.ServerAddress(raftAddr), 0, 0)
-	if f.Error() != nil {
+	alreadyMember, err := cluster.AddServer(h.raft, nodeID, raftAddr, form.NonVoter)
+	if err != nil {
 		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
-			"error": fmt.Sprintf("error add voter: %s", f.Error().Error()),
+			"error": err.Error(),
 		})
 	}
 
+	if form.HTTPAddress != "" {
+		err := h.apply(fsm.CommandPayload{
+			Operation: "member_set",
+			Key:       []byte(nodeID),
+			Value:     []byte(form.HTTPAddress),
+		})
+		if err != nil {
+			return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+				"error": fmt.Sprintf("error recording http address: %s", err.Error()),
+			})
+		}
+	}
+
+	message := fmt.Sprintf("node %s at %s joined successfully", nodeID, raftAddr)
+	if alreadyMember {
+		message = fmt.Sprintf("node %s at %s is already a member", nodeID, raftAddr)
+	}
 	return eCtx.JSON(http.StatusOK, map[string]interface{}{
-		"message": fmt.Sprintf("node %s at %s joined successfully", nodeID, raftAddr),
+		"message": message,
 		"data":    h.raft.Stats(),
 	})
 }
@@ -0,0 +1,23 @@
+package raft_handler
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// MembersRaftHandler lists the servers of the cluster. On the leader it includes
+// the replication state of every member.
+func (h handler) MembersRaftHandler(eCtx echo.Context) error {
+	members, err := h.monitor.Members()
+	if err != nil {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+
+	return eCtx.JSON(http.StatusOK, map[string]interface{}{
+		"message": "success fetching members",
+		"data":    members,
+	})
+}
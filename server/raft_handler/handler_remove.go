@@ -6,11 +6,15 @@ import (
 
 	"github.com/hashicorp/raft"
 	"github.com/labstack/echo/v4"
+	"github.com/rohankmr414/arima/cluster"
 )
 
 // requestRemove request payload for removing node from raft cluster
 type requestRemove struct {
 	NodeID string `json:"node_id"`
+	// Force removes the node even if it is the last voter, or if the voters
+	// left could not form a quorum.
+	Force bool `json:"force"`
 }
 
 // RemoveRaftHandler handling removing raft
@@ -37,10 +41,46 @@ func (h handler) RemoveRaftHandler(eCtx echo.Context) error {
 		})
 	}
 
-	future := h.raft.RemoveServer(raft.ServerID(nodeID), 0, 0)
-	if err := future.Error(); err != nil {
+	var member *raft.Server
+	voters := 0
+	for _, server := range configFuture.Configuration().Servers {
+		if server.Suffrage == raft.Voter {
+			voters++
+		}
+		if server.ID == raft.ServerID(nodeID) {
+			server := server
+			member = &server
+		}
+	}
+	if member == nil {
+		return eCtx.JSON(http.StatusOK, map[string]interface{}{
+			"message": fmt.Sprintf("node %s is not a member", nodeID),
+			"data":    h.raft.Stats(),
+		})
+	}
+
+	if member.Suffrage == raft.Voter && !form.Force {
+		if voters == 1 {
+			return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+				"error": fmt.Sprintf("node %s is the last voter, set force to remove it", nodeID),
+			})
+		}
+		reachable, err := h.reachableVoters(member.ID)
+		if err != nil {
+			return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		if quorum := (voters-1)/2 + 1; reachable < quorum {
+			return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+				"error": fmt.Sprintf("removing node %s leaves %d reachable voters, short of a quorum of %d, set force to remove it", nodeID, reachable, quorum),
+			})
+		}
+	}
+
+	if err := cluster.RemoveServer(h.raft, h.fsm, nodeID, configFuture.Index()); err != nil {
 		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
-			"error": fmt.Sprintf("error removing existing node %s: %s", nodeID, err.Error()),
+			"error": err.Error(),
 		})
 	}
 
@@ -49,3 +89,19 @@ func (h handler) RemoveRaftHandler(eCtx echo.Context) error {
 		"data":    h.raft.Stats(),
 	})
 }
+
+// reachableVoters counts the voters other than nodeID that the heartbeats of the
+// leader reach, the leader included.
+func (h handler) reachableVoters(nodeID raft.ServerID) (int, error) {
+	members, err := h.monitor.Members()
+	if err != nil {
+		return 0, err
+	}
+	reachable := 0
+	for _, member := range members {
+		if member.NodeID != string(nodeID) && member.Suffrage == raft.Voter.String() && member.Reachable != nil && *member.Reachable {
+			reachable++
+		}
+	}
+	return reachable, nil
+}
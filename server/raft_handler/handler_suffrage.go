@@ -0,0 +1,84 @@
+package raft_handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/hashicorp/raft"
+	"github.com/labstack/echo/v4"
+)
+
+// requestSuffrage request payload for promoting or demoting a member
+type requestSuffrage struct {
+	NodeID string `json:"node_id"`
+}
+
+// PromoteRaftHandler makes a non-voting member a voter
+func (h handler) PromoteRaftHandler(eCtx echo.Context) error {
+	return h.changeSuffrage(eCtx, raft.Voter)
+}
+
+// DemoteRaftHandler makes a voter a non-voting member
+func (h handler) DemoteRaftHandler(eCtx echo.Context) error {
+	return h.changeSuffrage(eCtx, raft.Nonvoter)
+}
+
+func (h handler) changeSuffrage(eCtx echo.Context, suffrage raft.ServerSuffrage) error {
+	form := requestSuffrage{}
+	if err := eCtx.Bind(&form); err != nil {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("error binding: %s", err.Error()),
+		})
+	}
+
+	if h.raft.State() != raft.Leader {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": "not the leader",
+		})
+	}
+
+	configFuture := h.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("failed to get raft configuration: %s", err.Error()),
+		})
+	}
+
+	var member *raft.Server
+	for _, server := range configFuture.Configuration().Servers {
+		if server.ID == raft.ServerID(form.NodeID) {
+			server := server
+			member = &server
+			break
+		}
+	}
+	if member == nil {
+		return eCtx.JSON(http.StatusNotFound, map[string]interface{}{
+			"error": fmt.Sprintf("node %s is not a member of the cluster", form.NodeID),
+		})
+	}
+
+	if member.Suffrage != suffrage {
+		var future raft.IndexFuture
+		if suffrage == raft.Voter {
+			future = h.raft.AddVoter(member.ID, member.Address, 0, 0)
+		} else {
+			if member.Address == h.raft.Leader() {
+				return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+					"error": "cannot demote the leader, transfer leadership first",
+				})
+			}
+			future = h.raft.DemoteVoter(member.ID, 0, 0)
+		}
+		if err := future.Error(); err != nil {
+			return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+				"error": fmt.Sprintf("error changing suffrage of node %s: %s", form.NodeID, err.Error()),
+			})
+		}
+	}
+
+	return eCtx.JSON(http.StatusOK, map[string]interface{}{
+		"message": fmt.Sprintf("node %s is now a %s", form.NodeID, suffrage),
+		"data":    h.raft.Stats(),
+	})
+}
@@ -0,0 +1,96 @@
+package raft_handler
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/labstack/echo/v4"
+)
+
+// leaderWait bounds how long a transfer waits for the new leader to be known,
+// after raft reports the transfer complete.
+const leaderWait = 2 * time.Second
+
+// requestTransfer request payload for transferring leadership
+type requestTransfer struct {
+	NodeID string `json:"node_id"`
+}
+
+// TransferLeadershipHandler hands leadership to the given node, or to the most up
+// to date voter when no node is given.
+func (h handler) TransferLeadershipHandler(eCtx echo.Context) error {
+	form := requestTransfer{}
+	if err := eCtx.Bind(&form); err != nil {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("error binding: %s", err.Error()),
+		})
+	}
+
+	if h.raft.State() != raft.Leader {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": "not the leader",
+		})
+	}
+	oldLeader := h.raft.Leader()
+
+	var future raft.Future
+	if form.NodeID == "" {
+		future = h.raft.LeadershipTransfer()
+	} else {
+		configFuture := h.raft.GetConfiguration()
+		if err := configFuture.Error(); err != nil {
+			return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+				"error": fmt.Sprintf("failed to get raft configuration: %s", err.Error()),
+			})
+		}
+
+		var target *raft.Server
+		for _, server := range configFuture.Configuration().Servers {
+			if server.ID == raft.ServerID(form.NodeID) {
+				server := server
+				target = &server
+				break
+			}
+		}
+		if target == nil {
+			return eCtx.JSON(http.StatusNotFound, map[string]interface{}{
+				"error": fmt.Sprintf("node %s is not a member of the cluster", form.NodeID),
+			})
+		}
+		if target.Suffrage != raft.Voter {
+			return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+				"error": fmt.Sprintf("node %s is not a voter", form.NodeID),
+			})
+		}
+		future = h.raft.LeadershipTransferToServer(target.ID, target.Address)
+	}
+
+	if err := future.Error(); err != nil {
+		return eCtx.JSON(http.StatusGatewayTimeout, map[string]interface{}{
+			"error": fmt.Sprintf("error transferring leadership: %s", err.Error()),
+		})
+	}
+
+	// The transfer completes once the target has been told to start an
+	// election, so wait for it to win.
+	deadline := time.Now().Add(leaderWait)
+	leader := h.raft.Leader()
+	for (leader == "" || leader == oldLeader) && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		leader = h.raft.Leader()
+	}
+	if leader == "" || leader == oldLeader {
+		return eCtx.JSON(http.StatusGatewayTimeout, map[string]interface{}{
+			"error": "timed out waiting for a new leader",
+		})
+	}
+
+	return eCtx.JSON(http.StatusOK, map[string]interface{}{
+		"message": fmt.Sprintf("leadership transferred to %s", leader),
+		"data": map[string]interface{}{
+			"leader": string(leader),
+		},
+	})
+}
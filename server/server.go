@@ -1,23 +1,59 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"net/http"
 	_ "net/http/pprof"
 	"time"
 
-	"github.com/dgraph-io/badger/v3"
 	"github.com/hashicorp/raft"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rohankmr414/arima/autopilot"
+	"github.com/rohankmr414/arima/cluster"
+	"github.com/rohankmr414/arima/fsm"
+	"github.com/rohankmr414/arima/metrics"
+	"github.com/rohankmr414/arima/server/acl_handler"
+	"github.com/rohankmr414/arima/server/admin_handler"
+	"github.com/rohankmr414/arima/server/deadline"
+	"github.com/rohankmr414/arima/server/health_handler"
+	"github.com/rohankmr414/arima/server/quota_handler"
 	"github.com/rohankmr414/arima/server/raft_handler"
 	"github.com/rohankmr414/arima/server/store_handler"
 )
 
+// Options configures the optional features of the server
+type Options struct {
+	// ACLEnabled requires a bearer token on every request.
+	ACLEnabled bool
+	// ACLMasterToken is always accepted with full privileges, so the first
+	// policies and tokens can be created.
+	ACLMasterToken string
+	// TLSConfig serves the API over TLS when set.
+	TLSConfig *tls.Config
+	// Stores are pinged by the readiness check, by name.
+	Stores map[string]health_handler.Pinger
+	// Monitor reports the replication state of the cluster members. One polling
+	// members without TLS is created when it is nil.
+	Monitor *cluster.Monitor
+	// SnapshotStore is the store raft keeps snapshots in, listed by
+	// /admin/snapshots.
+	SnapshotStore raft.SnapshotStore
+	// Autopilot is reported on by /raft/autopilot when set.
+	Autopilot *autopilot.Autopilot
+	// ReadyMaxLag is how many entries the applied index may trail the commit
+	// index while the node is still ready.
+	ReadyMaxLag uint64
+}
+
 // srv struct handling server
 type srv struct {
 	listenAddress string
 	raft          *raft.Raft
 	echo          *echo.Echo
+	tlsConfig     *tls.Config
 }
 
 // Start start the server
@@ -26,32 +62,94 @@ func (s srv) Start() error {
 		Addr:         s.listenAddress,
 		ReadTimeout:  3 * time.Second,
 		WriteTimeout: 3 * time.Second,
+		TLSConfig:    s.tlsConfig,
+		ConnContext:  deadline.ConnContext,
 	})
 }
 
 // New return new server
-func New(listenAddr string, badgerDB *badger.DB, r *raft.Raft) *srv {
+func New(listenAddr string, arimaFsm *fsm.ArimaFSM, r *raft.Raft, opts Options) *srv {
 	e := echo.New()
 	e.HideBanner = true
 	e.HidePort = true
 	e.Pre(middleware.RemoveTrailingSlash())
-	e.GET("/debug/pprof/*", echo.WrapHandler(http.DefaultServeMux))
+	e.Use(metrics.Middleware)
+	e.Use(authenticate(opts, arimaFsm))
+	e.GET("/debug/pprof/*", echo.WrapHandler(http.DefaultServeMux), requireClusterAdmin)
+	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()), requireClusterAdmin)
+
+	// Health server, open to orchestrators without a token
+	healthHandler := health_handler.New(r, opts.Stores, opts.ReadyMaxLag)
+	e.GET("/health/live", healthHandler.Live)
+	e.GET("/health/ready", healthHandler.Ready)
+	e.GET("/health/leader", healthHandler.Leader)
 
 	// Raft server
-	raftHandler := raft_handler.New(r)
-	e.POST("/raft/join", raftHandler.JoinRaftHandler)
-	e.POST("/raft/remove", raftHandler.RemoveRaftHandler)
-	e.GET("/raft/stats", raftHandler.StatsRaftHandler)
+	monitor := opts.Monitor
+	if monitor == nil {
+		monitor = cluster.NewMonitor(r, arimaFsm, nil)
+	}
+	raftHandler := raft_handler.New(r, arimaFsm, monitor, opts.Autopilot)
+	raftGroup := e.Group("/raft", requireClusterAdmin)
+	raftGroup.POST("/join", raftHandler.JoinRaftHandler)
+	raftGroup.POST("/remove", raftHandler.RemoveRaftHandler)
+	raftGroup.GET("/stats", raftHandler.StatsRaftHandler)
+	raftGroup.GET("/members", raftHandler.MembersRaftHandler)
+	raftGroup.GET("/autopilot", raftHandler.AutopilotRaftHandler)
+	raftGroup.POST("/promote", raftHandler.PromoteRaftHandler)
+	raftGroup.POST("/demote", raftHandler.DemoteRaftHandler)
+	raftGroup.POST("/transfer-leadership", raftHandler.TransferLeadershipHandler)
 
 	// Store server
-	storeHandler := store_handler.New(r, badgerDB)
+	storeHandler := store_handler.New(r, arimaFsm)
 	e.POST("/store", storeHandler.Set)
 	e.GET("/store/:key", storeHandler.Get)
 	e.DELETE("/store/:key", storeHandler.Delete)
 
+	// Quota server
+	quotaHandler := quota_handler.New(r, arimaFsm)
+	e.GET("/quota", quotaHandler.List)
+	e.POST("/quota", quotaHandler.Set)
+	e.GET("/quota/:namespace", quotaHandler.Get)
+	e.DELETE("/quota/:namespace", quotaHandler.Delete)
+
+	// Admin server
+	adminHandler := admin_handler.New(r, arimaFsm, opts.SnapshotStore)
+	adminGroup := e.Group("/admin", requireClusterAdmin)
+	adminGroup.GET("/backup", adminHandler.Backup)
+	adminGroup.POST("/snapshot", adminHandler.TakeSnapshot)
+	if opts.SnapshotStore != nil {
+		adminGroup.GET("/snapshots", adminHandler.ListSnapshots)
+		adminGroup.GET("/snapshots/:id", adminHandler.DownloadSnapshot)
+	}
+
+	// ACL server
+	aclHandler := acl_handler.New(r, arimaFsm)
+	aclGroup := e.Group("/acl", requireClusterAdmin)
+	aclGroup.GET("/policy", aclHandler.ListPolicies)
+	aclGroup.POST("/policy", aclHandler.SetPolicy)
+	aclGroup.GET("/policy/:name", aclHandler.GetPolicy)
+	aclGroup.DELETE("/policy/:name", aclHandler.DeletePolicy)
+	aclGroup.GET("/token", aclHandler.ListTokens)
+	aclGroup.POST("/token", aclHandler.SetToken)
+	aclGroup.GET("/token/:accessor_id", aclHandler.GetToken)
+	aclGroup.DELETE("/token/:accessor_id", aclHandler.DeleteToken)
+
 	return &srv{
 		listenAddress: listenAddr,
 		echo:          e,
 		raft:          r,
+		tlsConfig:     opts.TLSConfig,
 	}
 }
+
+// Shutdown stops accepting requests and waits for in-flight requests to finish
+// until ctx is done.
+func (s srv) Shutdown(ctx context.Context) error {
+	return s.echo.Shutdown(ctx)
+}
+
+// Close stops serving at once, closing the connections of in-flight requests.
+func (s srv) Close() error {
+	return s.echo.Close()
+}
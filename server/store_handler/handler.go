@@ -1,19 +1,19 @@
 package store_handler
 
 import (
-	"github.com/dgraph-io/badger/v3"
 	"github.com/hashicorp/raft"
+	"github.com/rohankmr414/arima/fsm"
 )
 
 // handler struct handler
 type handler struct {
 	raft *raft.Raft
-	db   *badger.DB
+	fsm  *fsm.ArimaFSM
 }
 
-func New(raft *raft.Raft, db *badger.DB) *handler {
+func New(raft *raft.Raft, fsm *fsm.ArimaFSM) *handler {
 	return &handler{
 		raft: raft,
-		db:   db,
+		fsm:  fsm,
 	}
 }
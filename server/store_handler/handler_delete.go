@@ -9,6 +9,7 @@ import (
 
 	"github.com/hashicorp/raft"
 	"github.com/labstack/echo/v4"
+	"github.com/rohankmr414/arima/acl"
 	"github.com/rohankmr414/arima/fsm"
 	"github.com/rohankmr414/arima/utils"
 )
@@ -25,6 +26,18 @@ func (h handler) Delete(eCtx echo.Context) error {
 
 	keyByte := []byte(key)
 
+	if fsm.IsReserved(keyByte) {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("key %s is in the reserved keyspace", key),
+		})
+	}
+
+	if !acl.FromContext(eCtx).KeyWrite(key) {
+		return eCtx.JSON(http.StatusForbidden, map[string]interface{}{
+			"error": fmt.Sprintf("permission denied: no write access to %s", key),
+		})
+	}
+
 	if h.raft.State() != raft.Leader {
 		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
 			"error": "not the leader",
@@ -51,13 +64,19 @@ func (h handler) Delete(eCtx echo.Context) error {
 		})
 	}
 
-	_, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	resp, ok := applyFuture.Response().(*fsm.ApplyResponse)
 	if !ok {
 		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
 			"error": "error response is not match apply response",
 		})
 	}
 
+	if resp.Error != nil {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("error removing data: %s", resp.Error.Error()),
+		})
+	}
+
 	return eCtx.JSON(http.StatusOK, map[string]interface{}{
 		"message": "success removing data",
 		"data": map[string]interface{}{
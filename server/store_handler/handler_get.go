@@ -6,6 +6,8 @@ import (
 	"strings"
 
 	"github.com/labstack/echo/v4"
+	"github.com/rohankmr414/arima/acl"
+	"github.com/rohankmr414/arima/fsm"
 )
 
 // Get will fetched data from badgerDB where the raft use to store data.
@@ -20,38 +22,27 @@ func (h handler) Get(eCtx echo.Context) error {
 
 	keyByte := []byte(key)
 
-	txn := h.db.NewTransaction(false)
-	defer func() {
-		_ = txn.Commit()
-	}()
-
-	item, err := txn.Get(keyByte)
-	if err != nil {
+	if fsm.IsReserved(keyByte) {
 		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
-			"error": fmt.Sprintf("error getting key %s from storage: %s", key, err.Error()),
+			"error": fmt.Sprintf("key %s is in the reserved keyspace", key),
 		})
 	}
 
-	value := make([]byte, 0)
-	err = item.Value(func(val []byte) error {
-		value = append(value, val...)
-		return nil
-	})
-
-	if err != nil {
-		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
-			"error": fmt.Sprintf("error appending byte value of key %s from storage: %s", key, err.Error()),
+	if !acl.FromContext(eCtx).KeyRead(key) {
+		return eCtx.JSON(http.StatusForbidden, map[string]interface{}{
+			"error": fmt.Sprintf("permission denied: no read access to %s", key),
 		})
 	}
 
-	data := string(value)
-
+	value, err := h.fsm.Get(keyByte)
 	if err != nil {
 		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
-			"error": fmt.Sprintf("error decoding value of key %s from storage: %s", key, err.Error()),
+			"error": fmt.Sprintf("error getting key %s from storage: %s", key, err.Error()),
 		})
 	}
 
+	data := string(value)
+
 	return eCtx.JSON(http.StatusOK, map[string]interface{}{
 		"message": "success fetching data",
 		"data": map[string]interface{}{
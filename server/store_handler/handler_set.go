@@ -1,12 +1,14 @@
 package store_handler
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/hashicorp/raft"
 	"github.com/labstack/echo/v4"
+	"github.com/rohankmr414/arima/acl"
 	"github.com/rohankmr414/arima/fsm"
 	"github.com/rohankmr414/arima/utils"
 )
@@ -32,6 +34,18 @@ func (h handler) Set(eCtx echo.Context) error {
 		})
 	}
 
+	if fsm.IsReserved([]byte(form.Key)) {
+		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error": fmt.Sprintf("key %s is in the reserved keyspace", form.Key),
+		})
+	}
+
+	if !acl.FromContext(eCtx).KeyWrite(form.Key) {
+		return eCtx.JSON(http.StatusForbidden, map[string]interface{}{
+			"error": fmt.Sprintf("permission denied: no write access to %s", form.Key),
+		})
+	}
+
 	if h.raft.State() != raft.Leader {
 		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
 			"error": "not the leader",
@@ -58,13 +72,23 @@ func (h handler) Set(eCtx echo.Context) error {
 		})
 	}
 
-	_, ok := applyFuture.Response().(*fsm.ApplyResponse)
+	resp, ok := applyFuture.Response().(*fsm.ApplyResponse)
 	if !ok {
 		return eCtx.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
 			"error": "error response is not match apply response",
 		})
 	}
 
+	if resp.Error != nil {
+		status := http.StatusUnprocessableEntity
+		if errors.Is(resp.Error, fsm.ErrQuotaExceeded) {
+			status = http.StatusInsufficientStorage
+		}
+		return eCtx.JSON(status, map[string]interface{}{
+			"error": fmt.Sprintf("error persisting data: %s", resp.Error.Error()),
+		})
+	}
+
 	return eCtx.JSON(http.StatusOK, map[string]interface{}{
 		"message": "success persisting data",
 		"data":    form,
@@ -0,0 +1,159 @@
+package store
+
+import (
+	"bytes"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// BadgerEngine is the engine of a badger database, or of the part of one under
+// a key prefix.
+type BadgerEngine struct {
+	view *View
+}
+
+// NewBadgerEngine returns the engine of view.
+func NewBadgerEngine(view *View) *BadgerEngine {
+	return &BadgerEngine{view: view}
+}
+
+// View runs fn in a read-only transaction.
+func (e *BadgerEngine) View(fn func(txn Txn) error) error {
+	return e.view.View(func(txn *ViewTxn) error {
+		return fn(badgerTxn{txn})
+	})
+}
+
+// Update runs fn in a read-write transaction.
+func (e *BadgerEngine) Update(fn func(txn Txn) error) error {
+	return e.view.Update(func(txn *ViewTxn) error {
+		return fn(badgerTxn{txn})
+	})
+}
+
+// Snapshot starts a read-only transaction. While it is open, badger keeps the
+// versions written since, so they can be iterated with Since.
+func (e *BadgerEngine) Snapshot() Snapshot {
+	return badgerSnapshot{badgerTxn{e.view.NewTransaction(false)}}
+}
+
+// NewBatch starts a write batch.
+func (e *BadgerEngine) NewBatch() Batch {
+	return e.view.NewWriteBatch()
+}
+
+// DropPrefix deletes every key starting with prefix.
+func (e *BadgerEngine) DropPrefix(prefix []byte) error {
+	return e.view.DropPrefix(prefix)
+}
+
+// Sync syncs the database to disk.
+func (e *BadgerEngine) Sync() error {
+	return e.view.DB().Sync()
+}
+
+// Size returns the LSM and value log sizes of the database.
+func (e *BadgerEngine) Size() (lsm, vlog int64) {
+	return e.view.DB().Size()
+}
+
+// IsClosed reports whether the database is closed.
+func (e *BadgerEngine) IsClosed() bool {
+	return e.view.DB().IsClosed()
+}
+
+type badgerTxn struct {
+	txn *ViewTxn
+}
+
+func (t badgerTxn) Get(key []byte) (Item, error) {
+	item, err := t.txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return badgerItem{item}, nil
+}
+
+func (t badgerTxn) Set(key, value []byte) error {
+	return t.txn.Set(key, value)
+}
+
+func (t badgerTxn) Delete(key []byte) error {
+	return t.txn.Delete(key)
+}
+
+func (t badgerTxn) NewIterator(opts IteratorOptions) Iterator {
+	bopts := badger.DefaultIteratorOptions
+	bopts.Prefix = opts.Prefix
+	bopts.Reverse = opts.Reverse
+	if opts.Since > 0 {
+		bopts.AllVersions = true
+		bopts.SinceTs = opts.Since
+	}
+	return &badgerIterator{it: t.txn.NewIterator(bopts), allVersions: bopts.AllVersions}
+}
+
+type badgerSnapshot struct {
+	badgerTxn
+}
+
+func (s badgerSnapshot) Version() uint64 {
+	return s.txn.ReadTs()
+}
+
+func (s badgerSnapshot) Discard() {
+	s.txn.Discard()
+}
+
+// badgerIterator only returns the newest version of each key when iterating
+// over all versions.
+type badgerIterator struct {
+	it          *ViewIterator
+	allVersions bool
+}
+
+func (i *badgerIterator) Rewind() {
+	i.it.Rewind()
+}
+
+func (i *badgerIterator) Seek(key []byte) {
+	i.it.Seek(key)
+}
+
+func (i *badgerIterator) Valid() bool {
+	return i.it.Valid()
+}
+
+func (i *badgerIterator) ValidForPrefix(prefix []byte) bool {
+	return i.it.ValidForPrefix(prefix)
+}
+
+func (i *badgerIterator) Next() {
+	if !i.allVersions {
+		i.it.Next()
+		return
+	}
+	// Versions come newest first.
+	key := i.it.Item().KeyCopy(nil)
+	for i.it.Next(); i.it.Valid() && bytes.Equal(i.it.Item().Key(), key); i.it.Next() {
+	}
+}
+
+func (i *badgerIterator) Item() Item {
+	return badgerItem{i.it.Item()}
+}
+
+func (i *badgerIterator) Close() {
+	i.it.Close()
+}
+
+type badgerItem struct {
+	*ViewItem
+}
+
+func (i badgerItem) IsDeleted() bool {
+	return i.IsDeletedOrExpired()
+}
@@ -0,0 +1,88 @@
+package store
+
+import "errors"
+
+// ErrNotFound is returned by engines for a missing key. Its message matches
+// badger's, which the API used to return.
+var ErrNotFound = errors.New("Key not found")
+
+// Engine is the ordered, transactional key-value store the FSM keeps its state
+// in.
+type Engine interface {
+	// View runs fn in a read-only transaction.
+	View(fn func(txn Txn) error) error
+	// Update runs fn in a read-write transaction, committed if fn returns nil.
+	Update(fn func(txn Txn) error) error
+	// Snapshot starts a read-only transaction pinned to the current state,
+	// which must be discarded once done.
+	Snapshot() Snapshot
+	// NewBatch batches writes. A batch is not atomic.
+	NewBatch() Batch
+	// DropPrefix deletes every key starting with prefix.
+	DropPrefix(prefix []byte) error
+	// Sync flushes the engine to disk, when it has one.
+	Sync() error
+	// Size returns the LSM and value log sizes on disk, zero when not on disk.
+	Size() (lsm, vlog int64)
+	IsClosed() bool
+}
+
+// Txn reads and writes keys. Get returns ErrNotFound for a missing key.
+type Txn interface {
+	Get(key []byte) (Item, error)
+	Set(key, value []byte) error
+	Delete(key []byte) error
+	NewIterator(opts IteratorOptions) Iterator
+}
+
+// Snapshot is a read-only transaction pinned to the state of the engine when
+// it was taken.
+type Snapshot interface {
+	Txn
+	// Version identifies the state the snapshot reads. Iterating with Since set
+	// to it returns the keys changed after the snapshot was taken.
+	Version() uint64
+	Discard()
+}
+
+// IteratorOptions configures an iterator.
+type IteratorOptions struct {
+	// Prefix restricts the iteration to the keys starting with it.
+	Prefix []byte
+	// Reverse iterates from the last key.
+	Reverse bool
+	// Since restricts the iteration to the keys changed after that version,
+	// deleted keys included. Zero iterates over the live keys.
+	Since uint64
+}
+
+// Iterator iterates over keys in order.
+type Iterator interface {
+	Rewind()
+	Seek(key []byte)
+	Valid() bool
+	ValidForPrefix(prefix []byte) bool
+	Next()
+	Item() Item
+	Close()
+}
+
+// Item is a key and its value. Key is only valid until the iterator moves.
+type Item interface {
+	Key() []byte
+	KeyCopy(dst []byte) []byte
+	Value(fn func(val []byte) error) error
+	ValueCopy(dst []byte) ([]byte, error)
+	// Version is the version the key was last written at.
+	Version() uint64
+	// IsDeleted reports a deleted key, only returned by iterators with Since set.
+	IsDeleted() bool
+}
+
+// Batch batches writes.
+type Batch interface {
+	Set(key, value []byte) error
+	Delete(key []byte) error
+	Flush() error
+	Cancel()
+}
@@ -0,0 +1,338 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// FSMStore holds the state of the FSM. A restored snapshot is loaded next to
+// the current state and only replaces it once loaded completely.
+type FSMStore interface {
+	// View returns the current state.
+	View() Engine
+	// Stage returns an empty engine to load a snapshot into.
+	Stage() (Engine, error)
+	// Commit replaces the current state with the staged one.
+	Commit() error
+	// Abort drops the staged state.
+	Abort() error
+	// SpoolPath is where a snapshot is written while it is verified, a
+	// temporary file when empty.
+	SpoolPath() string
+	Close() error
+}
+
+// A snapshot is spooled and loaded next to the database directory, which is
+// moved aside while the restored database is moved in.
+const (
+	spoolSuffix   = ".snapshot.tmp"
+	stagingSuffix = ".restore"
+	oldSuffix     = ".old"
+)
+
+// rootFSMName names what is kept next to an FSM database at the root of a
+// volume, inside the volume.
+const rootFSMName = "fsm"
+
+// DirFSMStore keeps the FSM in a badger database of its own.
+type DirFSMStore struct {
+	dir string
+	// root is set when the database shares its directory with the other
+	// stores, at the root of a volume. Its files are then moved one by one.
+	root    bool
+	opts    Options
+	db      *badger.DB
+	staging *badger.DB
+}
+
+// NewFSMStore opens the FSM database at path.
+func NewFSMStore(path string, opts Options) (*DirFSMStore, error) {
+	if err := recoverSwap(path); err != nil {
+		return nil, err
+	}
+	return openFSMStore(&DirFSMStore{dir: path, opts: opts})
+}
+
+// NewRootFSMStore opens the FSM database kept at the root of volume, next to
+// the directories of the other stores.
+func NewRootFSMStore(volume string, opts Options) (*DirFSMStore, error) {
+	if err := RecoverRootFSM(volume); err != nil {
+		return nil, err
+	}
+	return openFSMStore(&DirFSMStore{dir: volume, root: true, opts: opts})
+}
+
+func openFSMStore(s *DirFSMStore) (*DirFSMStore, error) {
+	db, err := badger.Open(s.opts.BadgerOptions(s.dir))
+	if err != nil {
+		return nil, err
+	}
+	s.db = db
+	return s, nil
+}
+
+// sibling returns the path kept next to the database with suffix.
+func (s *DirFSMStore) sibling(suffix string) string {
+	if s.root {
+		return rootSibling(s.dir, suffix)
+	}
+	return s.dir + suffix
+}
+
+func rootSibling(volume, suffix string) string {
+	return filepath.Join(volume, rootFSMName+suffix)
+}
+
+// View returns the current database.
+func (s *DirFSMStore) View() Engine {
+	return NewBadgerEngine(NewView(s.db, nil))
+}
+
+// Stage opens a new database next to the current one.
+func (s *DirFSMStore) Stage() (Engine, error) {
+	staging := s.sibling(stagingSuffix)
+	if err := os.RemoveAll(staging); err != nil {
+		return nil, err
+	}
+	db, err := badger.Open(s.opts.BadgerOptions(staging))
+	if err != nil {
+		return nil, err
+	}
+	s.staging = db
+	return NewBadgerEngine(NewView(db, nil)), nil
+}
+
+// Abort closes and removes the staged database.
+func (s *DirFSMStore) Abort() error {
+	if s.staging == nil {
+		return nil
+	}
+	err := s.staging.Close()
+	s.staging = nil
+	if rerr := os.RemoveAll(s.sibling(stagingSuffix)); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// Commit replaces the database with the staged one. The current data is moved
+// aside until the new database is opened, and kept if that fails.
+func (s *DirFSMStore) Commit() error {
+	if err := s.staging.Close(); err != nil {
+		s.staging = nil
+		return err
+	}
+	s.staging = nil
+	if err := s.db.Close(); err != nil {
+		return err
+	}
+	old := s.sibling(oldSuffix)
+	if err := os.RemoveAll(old); err != nil {
+		return s.reopen(err)
+	}
+	if err := s.swap(old); err != nil {
+		return s.reopen(err)
+	}
+
+	db, err := badger.Open(s.opts.BadgerOptions(s.dir))
+	if err != nil {
+		return s.reopen(err)
+	}
+	s.db = db
+	if err := os.RemoveAll(s.sibling(stagingSuffix)); err != nil {
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+// swap moves the database to old and the staged one in its place. At the root
+// of a volume the files are moved one by one, MANIFEST last, so a database
+// whose MANIFEST moved has been moved completely.
+func (s *DirFSMStore) swap(old string) error {
+	staging := s.sibling(stagingSuffix)
+	if !s.root {
+		if err := os.Rename(s.dir, old); err != nil {
+			return err
+		}
+		return os.Rename(staging, s.dir)
+	}
+	if err := os.Mkdir(old, 0o700); err != nil {
+		return err
+	}
+	if err := moveBadgerFiles(s.dir, old); err != nil {
+		return err
+	}
+	return moveBadgerFiles(staging, s.dir)
+}
+
+// reopen reopens the current data after a failed swap, returning err.
+func (s *DirFSMStore) reopen(err error) error {
+	undo := undoSwap
+	if s.root {
+		undo = undoRootSwap
+	}
+	if rerr := undo(s.dir); rerr != nil {
+		return fmt.Errorf("error swapping in restored snapshot: %s, and recovering the previous state: %s", err, rerr)
+	}
+	db, rerr := badger.Open(s.opts.BadgerOptions(s.dir))
+	if rerr != nil {
+		return fmt.Errorf("error swapping in restored snapshot: %s, and reopening the previous state: %s", err, rerr)
+	}
+	s.db = db
+	return fmt.Errorf("error swapping in restored snapshot, keeping the current state: %s", err)
+}
+
+// SpoolPath returns the path next to the database directory.
+func (s *DirFSMStore) SpoolPath() string {
+	return s.sibling(spoolSuffix)
+}
+
+// Close closes the badger database.
+func (s *DirFSMStore) Close() error {
+	return s.db.Close()
+}
+
+// recoverSwap cleans up after a restore interrupted by a crash. If the database
+// was moved aside but the restored one not moved in, the old one is put back;
+// raft restores the snapshot again on start.
+func recoverSwap(dir string) error {
+	old := dir + oldSuffix
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if _, err := os.Stat(old); err == nil {
+			if err := os.Rename(old, dir); err != nil {
+				return err
+			}
+		}
+	}
+	for _, leftover := range []string{old, dir + stagingSuffix, dir + spoolSuffix} {
+		if err := os.RemoveAll(leftover); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// undoSwap puts the database moved aside by a failed swap back in place.
+func undoSwap(dir string) error {
+	old := dir + oldSuffix
+	if _, err := os.Stat(old); os.IsNotExist(err) {
+		return nil
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return os.Rename(old, dir)
+}
+
+// RecoverRootFSM cleans up after a restore of the FSM database at the root of
+// volume interrupted by a crash. A restored database moved in completely is
+// kept; otherwise the previous one is put back and raft restores the snapshot
+// again on start.
+func RecoverRootFSM(volume string) error {
+	old := rootSibling(volume, oldSuffix)
+	if exists(filepath.Join(old, "MANIFEST")) && exists(filepath.Join(volume, "MANIFEST")) {
+		if err := os.RemoveAll(old); err != nil {
+			return err
+		}
+	} else if err := undoRootSwap(volume); err != nil {
+		return err
+	}
+	for _, suffix := range []string{stagingSuffix, spoolSuffix} {
+		if err := os.RemoveAll(rootSibling(volume, suffix)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveRootFSM deletes the FSM database at the root of volume, and what a
+// restore left next to it.
+func RemoveRootFSM(volume string) error {
+	if err := RecoverRootFSM(volume); err != nil {
+		return err
+	}
+	return removeBadgerFiles(volume)
+}
+
+// undoRootSwap puts the database moved aside by a failed swap back at the root
+// of volume. Once it was moved completely, the files at the root are those of
+// the restored database and are dropped.
+func undoRootSwap(volume string) error {
+	old := rootSibling(volume, oldSuffix)
+	if !exists(old) {
+		return nil
+	}
+	if exists(filepath.Join(old, "MANIFEST")) {
+		if err := removeBadgerFiles(volume); err != nil {
+			return err
+		}
+	}
+	if err := moveBadgerFiles(old, volume); err != nil {
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+// moveBadgerFiles moves the files of the badger database in src to dst,
+// MANIFEST last.
+func moveBadgerFiles(src, dst string) error {
+	names, err := badgerFiles(src)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := os.Rename(filepath.Join(src, name), filepath.Join(dst, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeBadgerFiles removes the files of the badger database in dir, MANIFEST
+// first.
+func removeBadgerFiles(dir string) error {
+	names, err := badgerFiles(dir)
+	if err != nil {
+		return err
+	}
+	for i := len(names) - 1; i >= 0; i-- {
+		if err := os.Remove(filepath.Join(dir, names[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// badgerFiles lists the files of the badger database in dir, MANIFEST last.
+func badgerFiles(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	manifest := false
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case name == "MANIFEST":
+			manifest = true
+		case name == "KEYREGISTRY", name == "DISCARD", name == "LOCK",
+			strings.HasSuffix(name, ".sst"), strings.HasSuffix(name, ".vlog"), strings.HasSuffix(name, ".mem"):
+			names = append(names, name)
+		}
+	}
+	if manifest {
+		names = append(names, "MANIFEST")
+	}
+	return names, nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
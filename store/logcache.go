@@ -0,0 +1,86 @@
+package store
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/raft"
+)
+
+// LogCache keeps the most recent log entries in memory in front of a log store,
+// so replicating them to followers does not read and decode them again.
+type LogCache struct {
+	store raft.LogStore
+
+	mu    sync.RWMutex
+	cache []*raft.Log
+}
+
+// NewLogCache caches the last capacity entries written to store.
+func NewLogCache(capacity int, store raft.LogStore) (*LogCache, error) {
+	if capacity <= 0 {
+		return nil, errors.New("log cache capacity must be positive")
+	}
+	return &LogCache{
+		store: store,
+		cache: make([]*raft.Log, capacity),
+	}, nil
+}
+
+// GetLog gets a log entry at a given index, from the cache when it holds it.
+func (c *LogCache) GetLog(index uint64, log *raft.Log) error {
+	c.mu.RLock()
+	cached := c.cache[index%uint64(len(c.cache))]
+	c.mu.RUnlock()
+
+	if cached != nil && cached.Index == index {
+		metrics.IncrCounter([]string{"store", "log", "cache", "hits"}, 1)
+		*log = *cached
+		return nil
+	}
+	metrics.IncrCounter([]string{"store", "log", "cache", "misses"}, 1)
+	return c.store.GetLog(index, log)
+}
+
+// StoreLog stores a log entry and caches it.
+func (c *LogCache) StoreLog(log *raft.Log) error {
+	return c.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs stores multiple log entries and caches them once they are stored.
+func (c *LogCache) StoreLogs(logs []*raft.Log) error {
+	if err := c.store.StoreLogs(logs); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, log := range logs {
+		c.cache[log.Index%uint64(len(c.cache))] = log
+	}
+	return nil
+}
+
+// FirstIndex returns the first index written. 0 for no entries.
+func (c *LogCache) FirstIndex() (uint64, error) {
+	return c.store.FirstIndex()
+}
+
+// LastIndex returns the last index written. 0 for no entries.
+func (c *LogCache) LastIndex() (uint64, error) {
+	return c.store.LastIndex()
+}
+
+// DeleteRange deletes a range of log entries and drops the cached ones.
+func (c *LogCache) DeleteRange(min, max uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, log := range c.cache {
+		if log != nil && log.Index >= min && log.Index <= max {
+			c.cache[i] = nil
+		}
+	}
+	return c.store.DeleteRange(min, max)
+}
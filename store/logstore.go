@@ -1,7 +1,11 @@
 package store
 
 import (
+	"errors"
 	"fmt"
+	"sync"
+
+	"github.com/armon/go-metrics"
 
 	"github.com/dgraph-io/badger/v3"
 	"github.com/hashicorp/raft"
@@ -10,28 +14,78 @@ import (
 
 type LogStore struct {
 	Conn *badger.DB
+	view *View
+	// shared is set when the database also holds other stores, which close it.
+	shared bool
+
+	// firstIndex and lastIndex are the lowest and highest indexes stored, kept
+	// in memory so raft does not open an iterator each time it asks for them.
+	// lastIndex also detects gaps between batches. indexMu is held while a
+	// write changes them, from the commit until they are updated.
+	indexMu    sync.RWMutex
+	firstIndex uint64
+	lastIndex  uint64
 }
 
-func NewLogStore(path string) (*LogStore, error) {
-	var err error
-	opts := badger.DefaultOptions(path)
-	opts.Logger = nil
-	opts.SyncWrites = true
+func NewLogStore(path string, opts Options) (*LogStore, error) {
+	handle, err := badger.Open(opts.BadgerOptions(path))
+	if err != nil {
+		return nil, err
+	}
 
-	handle, err := badger.Open(opts)
+	store, err := newLogStore(NewView(handle, nil), false)
 	if err != nil {
+		handle.Close()
 		return nil, err
 	}
+	return store, nil
+}
 
-	return &LogStore{
-		Conn: handle,
-	}, nil
+func newLogStore(view *View, shared bool) (*LogStore, error) {
+	store := &LogStore{
+		Conn:   view.DB(),
+		view:   view,
+		shared: shared,
+	}
+	if err := store.loadIndexes(); err != nil {
+		return nil, err
+	}
+	return store, nil
 }
 
 // FirstIndex returns the first index written. 0 for no entries.
 func (store *LogStore) FirstIndex() (uint64, error) {
+	store.indexMu.RLock()
+	defer store.indexMu.RUnlock()
+	return store.firstIndex, nil
+}
+
+// LastIndex returns the last index written. 0 for no entries.
+func (store *LogStore) LastIndex() (uint64, error) {
+	store.indexMu.RLock()
+	defer store.indexMu.RUnlock()
+	return store.lastIndex, nil
+}
+
+// loadIndexes reads the first and last index from badger.
+func (store *LogStore) loadIndexes() error {
+	first, err := store.readFirstIndex()
+	if err != nil {
+		return err
+	}
+	last, err := store.readLastIndex()
+	if err != nil {
+		return err
+	}
+	store.indexMu.Lock()
+	store.firstIndex, store.lastIndex = first, last
+	store.indexMu.Unlock()
+	return nil
+}
+
+func (store *LogStore) readFirstIndex() (uint64, error) {
 	var key uint64
-	err := store.Conn.View(func(txn *badger.Txn) error {
+	err := store.view.View(func(txn *ViewTxn) error {
 		opts := badger.DefaultIteratorOptions
 		opts.PrefetchSize = 10
 		it := txn.NewIterator(opts)
@@ -55,10 +109,9 @@ func (store *LogStore) FirstIndex() (uint64, error) {
 	return key, nil
 }
 
-// LastIndex returns the last index written. 0 for no entries.
-func (store *LogStore) LastIndex() (uint64, error) {
+func (store *LogStore) readLastIndex() (uint64, error) {
 	var key uint64
-	err := store.Conn.View(func(txn *badger.Txn) error {
+	err := store.view.View(func(txn *ViewTxn) error {
 		opts := badger.DefaultIteratorOptions
 		opts.PrefetchSize = 10
 		opts.Reverse = true
@@ -87,7 +140,7 @@ func (store *LogStore) LastIndex() (uint64, error) {
 func (store *LogStore) GetLog(index uint64, log *raft.Log) error {
 	key := utils.Uint64ToBytes(index)
 	var value []byte
-	err := store.Conn.View(func(txn *badger.Txn) error {
+	err := store.view.View(func(txn *ViewTxn) error {
 		item, err := txn.Get(key)
 		if err != nil {
 			return err
@@ -95,6 +148,10 @@ func (store *LogStore) GetLog(index uint64, log *raft.Log) error {
 		value, err = item.ValueCopy(value)
 		return err
 	})
+	if err == badger.ErrKeyNotFound {
+		// Raft sends a snapshot to followers that need compacted entries.
+		return raft.ErrLogNotFound
+	}
 	if err != nil {
 		return err
 	}
@@ -104,29 +161,57 @@ func (store *LogStore) GetLog(index uint64, log *raft.Log) error {
 
 // StoreLog stores a log entry.
 func (store *LogStore) StoreLog(log *raft.Log) error {
-	key := utils.Uint64ToBytes(log.Index)
-	val, err := utils.EncodeMsgPack(log)
-	if err != nil {
-		return err
-	}
-	storeerr := store.Conn.Update(func(txn *badger.Txn) error {
-		err := txn.Set(key, val.Bytes())
-		if err != nil {
-			return err
-		}
-		return nil
-	})
+	return store.StoreLogs([]*raft.Log{log})
+}
 
-	return storeerr
+// trackIndex records a stored index, counting a gap when it does not follow the
+// previous last index. indexMu must be held.
+func (store *LogStore) trackIndex(index uint64) {
+	last := store.lastIndex
+	if last != 0 && index > last+1 {
+		metrics.IncrCounter([]string{"store", "log", "index_gaps"}, 1)
+	}
+	if index > last {
+		store.lastIndex = index
+		metrics.SetGauge([]string{"store", "log", "last_index"}, float32(index))
+	}
+	if store.firstIndex == 0 || index < store.firstIndex {
+		store.firstIndex = index
+	}
 }
 
-// StoreLogs stores multiple log entries.
+// StoreLogs stores multiple log entries in a single transaction, so the batch
+// costs one sync and is persisted as a whole. A batch too large for one badger
+// transaction fails with badger.ErrTxnTooBig and none of it is stored. Raft
+// bounds its batches with MaxAppendEntries, which must leave them small enough.
 func (store *LogStore) StoreLogs(logs []*raft.Log) error {
+	txn := store.view.NewTransaction(true)
+	defer txn.Discard()
+
 	for _, log := range logs {
-		if err := store.StoreLog(log); err != nil {
+		val, err := utils.EncodeMsgPack(log)
+		if err != nil {
+			return err
+		}
+		err = txn.Set(utils.Uint64ToBytes(log.Index), val.Bytes())
+		if err == badger.ErrTxnTooBig {
+			return fmt.Errorf("error storing %d log entries in one transaction, lower MaxAppendEntries: %w", len(logs), err)
+		}
+		if err != nil {
 			return err
 		}
 	}
+
+	store.indexMu.Lock()
+	defer store.indexMu.Unlock()
+	if err := txn.Commit(); err != nil {
+		return err
+	}
+
+	metrics.AddSample([]string{"store", "log", "batch_size"}, float32(len(logs)))
+	for _, log := range logs {
+		store.trackIndex(log.Index)
+	}
 	return nil
 }
 
@@ -134,26 +219,74 @@ func (store *LogStore) StoreLogs(logs []*raft.Log) error {
 func (store *LogStore) DeleteRange(min, max uint64) error {
 	minkey := utils.Uint64ToBytes(min)
 
-	txn := store.Conn.NewTransaction(true)
-	defer txn.Discard()
-
-	it := txn.NewIterator(badger.DefaultIteratorOptions)
-	it.Seek(minkey)
+	// Collect the keys first: a transaction with open iterators cannot be
+	// committed, and large ranges are deleted over several transactions.
+	var keys [][]byte
+	err := store.view.View(func(txn *ViewTxn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
 
-	for {
-		key := it.Item().Key()
+		for it.Seek(minkey); it.Valid(); it.Next() {
+			key := it.Item().KeyCopy(nil)
+			if utils.BytesToUint64(key) > max {
+				break
+			}
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting range: %v", err)
+	}
 
-		if utils.BytesToUint64(key) > max {
-			break
+	batch := store.view.NewWriteBatch()
+	defer batch.Cancel()
+	for _, key := range keys {
+		if err := batch.Delete(key); err != nil {
+			return fmt.Errorf("error deleting range: %v", err)
 		}
-		err := txn.Delete(key)
+	}
+	if err := batch.Flush(); err != nil {
+		return fmt.Errorf("error deleting range: %v", err)
+	}
+
+	// Compaction deletes from the head while raft appends to the tail, so only
+	// the ends the range covered are read again. The bounds are read under
+	// indexMu, so an append committed meanwhile is not lost.
+	store.indexMu.Lock()
+	defer store.indexMu.Unlock()
+	if min <= store.firstIndex {
+		first, err := store.readFirstIndex()
 		if err != nil {
 			return err
 		}
-		it.Next()
+		store.firstIndex = first
 	}
-	if err := txn.Commit(); err != nil {
-		return fmt.Errorf("error deleting range: %v", err)
+	if max >= store.lastIndex {
+		last, err := store.readLastIndex()
+		if err != nil {
+			return err
+		}
+		store.lastIndex = last
 	}
 	return nil
 }
+
+// Ping checks the store is open and can sync to disk. Nothing is written, since
+// every key of the log store is a log index.
+func (store *LogStore) Ping() error {
+	if store.Conn.IsClosed() {
+		return errors.New("log store is closed")
+	}
+	return store.Conn.Sync()
+}
+
+// Close closes the badger database, unless it is shared.
+func (store *LogStore) Close() error {
+	if store.shared {
+		return nil
+	}
+	return store.Conn.Close()
+}
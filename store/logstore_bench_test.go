@@ -0,0 +1,96 @@
+package store
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+	"github.com/rohankmr414/arima/utils"
+)
+
+// batchSizes are the numbers of entries per StoreLogs call benchmarked, up to
+// raft's largest MaxAppendEntries.
+var batchSizes = []int{1, 64, 1024}
+
+// perEntryLogStore stores every entry in a transaction of its own, the way the
+// log store did before batches were written in one transaction.
+type perEntryLogStore struct {
+	*LogStore
+}
+
+func (store perEntryLogStore) StoreLogs(logs []*raft.Log) error {
+	for _, log := range logs {
+		val, err := utils.EncodeMsgPack(log)
+		if err != nil {
+			return err
+		}
+		err = store.Conn.Update(func(txn *badger.Txn) error {
+			return txn.Set(utils.Uint64ToBytes(log.Index), val.Bytes())
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func BenchmarkStoreLogs(b *testing.B) {
+	for _, size := range batchSizes {
+		b.Run(fmt.Sprintf("batch=%d", size), func(b *testing.B) {
+			benchmarkStoreLogs(b, openBenchLogStore(b), size)
+		})
+	}
+}
+
+func BenchmarkStoreLogsPerEntry(b *testing.B) {
+	for _, size := range batchSizes {
+		b.Run(fmt.Sprintf("batch=%d", size), func(b *testing.B) {
+			benchmarkStoreLogs(b, perEntryLogStore{openBenchLogStore(b)}, size)
+		})
+	}
+}
+
+func BenchmarkStoreLogsBoltDB(b *testing.B) {
+	for _, size := range batchSizes {
+		b.Run(fmt.Sprintf("batch=%d", size), func(b *testing.B) {
+			store, err := raftboltdb.NewBoltStore(filepath.Join(b.TempDir(), "raft.db"))
+			if err != nil {
+				b.Fatalf("error opening bolt store: %s", err)
+			}
+			defer store.Close()
+			benchmarkStoreLogs(b, store, size)
+		})
+	}
+}
+
+func openBenchLogStore(b *testing.B) *LogStore {
+	b.Helper()
+	store, err := NewLogStore(b.TempDir(), Options{})
+	if err != nil {
+		b.Fatalf("error opening log store: %s", err)
+	}
+	b.Cleanup(func() { store.Close() })
+	return store
+}
+
+// benchmarkStoreLogs appends batches of size entries carrying a 256 byte
+// command, reporting the time per batch.
+func benchmarkStoreLogs(b *testing.B, store raft.LogStore, size int) {
+	data := make([]byte, 256)
+	index := uint64(1)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		logs := make([]*raft.Log, size)
+		for j := range logs {
+			logs[j] = &raft.Log{Index: index, Term: 1, Type: raft.LogCommand, Data: data}
+			index++
+		}
+		if err := store.StoreLogs(logs); err != nil {
+			b.Fatalf("error storing logs: %s", err)
+		}
+	}
+}
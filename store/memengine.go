@@ -0,0 +1,403 @@
+package store
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var errEngineClosed = errors.New("engine is closed")
+
+// memEntry is the latest version of a key. Entries are never modified, only
+// replaced, so snapshots can share them.
+type memEntry struct {
+	value   []byte
+	version uint64
+	deleted bool
+}
+
+// MemoryEngine keeps keys in a map. Deleted keys are kept as tombstones while
+// a snapshot taken before the delete is open, so iterating with Since returns
+// them. Snapshots copy the map, which makes them cost O(keys).
+type MemoryEngine struct {
+	mu      sync.RWMutex
+	data    map[string]*memEntry
+	version uint64
+	// pinned counts the open snapshots by version.
+	pinned map[uint64]int
+	closed bool
+}
+
+// NewMemoryEngine returns an empty engine.
+func NewMemoryEngine() *MemoryEngine {
+	return &MemoryEngine{
+		data:   map[string]*memEntry{},
+		pinned: map[uint64]int{},
+	}
+}
+
+// View runs fn in a read-only transaction.
+func (e *MemoryEngine) View(fn func(txn Txn) error) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		return errEngineClosed
+	}
+	return fn(&memTxn{data: e.data})
+}
+
+// Update runs fn in a read-write transaction. Transactions run one at a time
+// and their writes are applied when fn returns nil.
+func (e *MemoryEngine) Update(fn func(txn Txn) error) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return errEngineClosed
+	}
+	txn := &memTxn{data: e.data, pending: map[string]*memEntry{}}
+	if err := fn(txn); err != nil {
+		return err
+	}
+	e.apply(txn.pending)
+	return nil
+}
+
+// apply writes entries at a new version. Called with mu held.
+func (e *MemoryEngine) apply(pending map[string]*memEntry) {
+	if len(pending) == 0 {
+		return
+	}
+	e.version++
+	for key, entry := range pending {
+		if entry.deleted && len(e.pinned) == 0 {
+			delete(e.data, key)
+			continue
+		}
+		entry.version = e.version
+		e.data[key] = entry
+	}
+}
+
+// Snapshot copies the current state.
+func (e *MemoryEngine) Snapshot() Snapshot {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	data := make(map[string]*memEntry, len(e.data))
+	for key, entry := range e.data {
+		data[key] = entry
+	}
+	e.pinned[e.version]++
+	return &memSnapshot{memTxn: memTxn{data: data}, engine: e, version: e.version}
+}
+
+// release unpins a snapshot and drops the tombstones no open snapshot needs.
+func (e *MemoryEngine) release(version uint64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.pinned[version]--; e.pinned[version] == 0 {
+		delete(e.pinned, version)
+	}
+	oldest := e.version
+	for v := range e.pinned {
+		if v < oldest {
+			oldest = v
+		}
+	}
+	for key, entry := range e.data {
+		if entry.deleted && (len(e.pinned) == 0 || entry.version <= oldest) {
+			delete(e.data, key)
+		}
+	}
+}
+
+// NewBatch starts a batch, applied as one transaction when flushed.
+func (e *MemoryEngine) NewBatch() Batch {
+	return &memBatch{engine: e, pending: map[string]*memEntry{}}
+}
+
+// DropPrefix deletes every key starting with prefix.
+func (e *MemoryEngine) DropPrefix(prefix []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return errEngineClosed
+	}
+	for key := range e.data {
+		if strings.HasPrefix(key, string(prefix)) {
+			delete(e.data, key)
+		}
+	}
+	return nil
+}
+
+// Sync does nothing, there is no disk to sync to.
+func (e *MemoryEngine) Sync() error {
+	if e.IsClosed() {
+		return errEngineClosed
+	}
+	return nil
+}
+
+// Size returns zero, nothing is stored on disk.
+func (e *MemoryEngine) Size() (lsm, vlog int64) {
+	return 0, 0
+}
+
+// IsClosed reports whether the engine is closed.
+func (e *MemoryEngine) IsClosed() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.closed
+}
+
+// Close drops the data.
+func (e *MemoryEngine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+	e.data = nil
+	return nil
+}
+
+// memTxn reads data, overlaid with the pending writes of an update.
+type memTxn struct {
+	data    map[string]*memEntry
+	pending map[string]*memEntry
+}
+
+func (t *memTxn) get(key string) (*memEntry, bool) {
+	if entry, ok := t.pending[key]; ok {
+		return entry, true
+	}
+	entry, ok := t.data[key]
+	return entry, ok
+}
+
+func (t *memTxn) Get(key []byte) (Item, error) {
+	entry, ok := t.get(string(key))
+	if !ok || entry.deleted {
+		return nil, ErrNotFound
+	}
+	return &memItem{key: []byte(key), entry: entry}, nil
+}
+
+func (t *memTxn) Set(key, value []byte) error {
+	if t.pending == nil {
+		return errors.New("transaction is read-only")
+	}
+	t.pending[string(key)] = &memEntry{value: append([]byte{}, value...)}
+	return nil
+}
+
+func (t *memTxn) Delete(key []byte) error {
+	if t.pending == nil {
+		return errors.New("transaction is read-only")
+	}
+	t.pending[string(key)] = &memEntry{deleted: true}
+	return nil
+}
+
+// NewIterator collects and sorts the matching keys up front.
+func (t *memTxn) NewIterator(opts IteratorOptions) Iterator {
+	prefix := string(opts.Prefix)
+	var keys []string
+	collect := func(data map[string]*memEntry, pending bool) {
+		for key := range data {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			if _, ok := t.pending[key]; ok && !pending {
+				continue
+			}
+			keys = append(keys, key)
+		}
+	}
+	collect(t.data, false)
+	collect(t.pending, true)
+	sort.Strings(keys)
+
+	it := &memIterator{txn: t, reverse: opts.Reverse, since: opts.Since}
+	for _, key := range keys {
+		entry, _ := t.get(key)
+		if opts.Since > 0 {
+			if entry.version <= opts.Since {
+				continue
+			}
+		} else if entry.deleted {
+			continue
+		}
+		it.keys = append(it.keys, key)
+	}
+	if opts.Reverse {
+		for l, r := 0, len(it.keys)-1; l < r; l, r = l+1, r-1 {
+			it.keys[l], it.keys[r] = it.keys[r], it.keys[l]
+		}
+	}
+	return it
+}
+
+type memSnapshot struct {
+	memTxn
+	engine  *MemoryEngine
+	version uint64
+	once    sync.Once
+}
+
+func (s *memSnapshot) Version() uint64 {
+	return s.version
+}
+
+func (s *memSnapshot) Discard() {
+	s.once.Do(func() {
+		s.engine.release(s.version)
+	})
+}
+
+type memIterator struct {
+	txn     *memTxn
+	keys    []string
+	pos     int
+	reverse bool
+	since   uint64
+}
+
+func (i *memIterator) Rewind() {
+	i.pos = 0
+}
+
+func (i *memIterator) Seek(key []byte) {
+	k := string(key)
+	i.pos = sort.Search(len(i.keys), func(n int) bool {
+		if i.reverse {
+			return i.keys[n] <= k
+		}
+		return i.keys[n] >= k
+	})
+}
+
+func (i *memIterator) Valid() bool {
+	return i.pos < len(i.keys)
+}
+
+func (i *memIterator) ValidForPrefix(prefix []byte) bool {
+	return i.Valid() && strings.HasPrefix(i.keys[i.pos], string(prefix))
+}
+
+func (i *memIterator) Next() {
+	i.pos++
+}
+
+func (i *memIterator) Item() Item {
+	key := i.keys[i.pos]
+	entry, _ := i.txn.get(key)
+	return &memItem{key: []byte(key), entry: entry}
+}
+
+func (i *memIterator) Close() {}
+
+type memItem struct {
+	key   []byte
+	entry *memEntry
+}
+
+func (i *memItem) Key() []byte {
+	return i.key
+}
+
+func (i *memItem) KeyCopy(dst []byte) []byte {
+	return append(dst[:0], i.key...)
+}
+
+func (i *memItem) Value(fn func(val []byte) error) error {
+	return fn(i.entry.value)
+}
+
+func (i *memItem) ValueCopy(dst []byte) ([]byte, error) {
+	return append(dst[:0], i.entry.value...), nil
+}
+
+func (i *memItem) Version() uint64 {
+	return i.entry.version
+}
+
+func (i *memItem) IsDeleted() bool {
+	return i.entry.deleted
+}
+
+// memBatch collects writes until flushed.
+type memBatch struct {
+	engine  *MemoryEngine
+	pending map[string]*memEntry
+}
+
+func (b *memBatch) Set(key, value []byte) error {
+	b.pending[string(key)] = &memEntry{value: append([]byte{}, value...)}
+	return nil
+}
+
+func (b *memBatch) Delete(key []byte) error {
+	b.pending[string(key)] = &memEntry{deleted: true}
+	return nil
+}
+
+func (b *memBatch) Flush() error {
+	b.engine.mu.Lock()
+	defer b.engine.mu.Unlock()
+	if b.engine.closed {
+		return errEngineClosed
+	}
+	b.engine.apply(b.pending)
+	b.pending = map[string]*memEntry{}
+	return nil
+}
+
+func (b *memBatch) Cancel() {
+	b.pending = map[string]*memEntry{}
+}
+
+// MemoryFSMStore keeps the FSM in memory. Restored snapshots are loaded into a
+// new engine, which replaces the current one.
+type MemoryFSMStore struct {
+	current *MemoryEngine
+	staging *MemoryEngine
+}
+
+// NewMemoryFSMStore returns an empty store.
+func NewMemoryFSMStore() *MemoryFSMStore {
+	return &MemoryFSMStore{current: NewMemoryEngine()}
+}
+
+// View returns the current engine.
+func (s *MemoryFSMStore) View() Engine {
+	return s.current
+}
+
+// Stage returns a new engine.
+func (s *MemoryFSMStore) Stage() (Engine, error) {
+	s.staging = NewMemoryEngine()
+	return s.staging, nil
+}
+
+// Commit replaces the current engine with the staged one.
+func (s *MemoryFSMStore) Commit() error {
+	s.current.Close()
+	s.current, s.staging = s.staging, nil
+	return nil
+}
+
+// Abort drops the staged engine.
+func (s *MemoryFSMStore) Abort() error {
+	s.staging = nil
+	return nil
+}
+
+// SpoolPath returns an empty path, snapshots are spooled to a temporary file.
+func (s *MemoryFSMStore) SpoolPath() string {
+	return ""
+}
+
+// Close drops the data.
+func (s *MemoryFSMStore) Close() error {
+	return s.current.Close()
+}
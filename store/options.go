@@ -0,0 +1,58 @@
+package store
+
+import (
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/rohankmr414/arima/encryption"
+)
+
+// defaultIndexCacheSize is used when encryption is enabled and no index cache
+// size is given, since badger otherwise keeps every decrypted index in memory.
+const defaultIndexCacheSize = 100 << 20
+
+// Options tunes the badger databases of a node.
+type Options struct {
+	// EncryptionKey encrypts the data at rest when set. It must be 16, 24 or 32 bytes.
+	EncryptionKey []byte
+	// EncryptionKeyRotation is how often badger rotates the data keys derived from
+	// EncryptionKey. Zero keeps badger's default of 10 days.
+	EncryptionKeyRotation time.Duration
+	// PreviousEncryptionKeys decrypt snapshots written before the key was rotated,
+	// including snapshots sent by peers that have not been rotated yet.
+	PreviousEncryptionKeys [][]byte
+	// IndexCacheSize in bytes.
+	IndexCacheSize int64
+	// FullSnapshotInterval makes every Nth snapshot a full one, the others only
+	// hold the changes since the previous snapshot. 1 or less disables
+	// incremental snapshots.
+	FullSnapshotInterval int
+	// SnapshotCompression is the codec snapshots and backups are compressed
+	// with, "zstd" or "none". Empty means zstd.
+	SnapshotCompression string
+}
+
+// Keyring returns the keys snapshots are encrypted and decrypted with, nil when
+// encryption is disabled.
+func (o Options) Keyring() *encryption.Keyring {
+	return encryption.NewKeyring(o.EncryptionKey, o.PreviousEncryptionKeys...)
+}
+
+// BadgerOptions returns the badger options for a database stored at path.
+func (o Options) BadgerOptions(path string) badger.Options {
+	opts := badger.DefaultOptions(path)
+	opts.Logger = nil
+	opts.SyncWrites = true
+
+	if len(o.EncryptionKey) > 0 {
+		opts.EncryptionKey = o.EncryptionKey
+		if o.EncryptionKeyRotation > 0 {
+			opts.EncryptionKeyRotationDuration = o.EncryptionKeyRotation
+		}
+		opts.IndexCacheSize = defaultIndexCacheSize
+	}
+	if o.IndexCacheSize > 0 {
+		opts.IndexCacheSize = o.IndexCacheSize
+	}
+	return opts
+}
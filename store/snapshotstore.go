@@ -0,0 +1,361 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/raft"
+)
+
+var (
+	// DeltaMagic starts the state of an incremental snapshot, followed by the ID
+	// of the snapshot it builds on. Only the snapshot store reads it.
+	DeltaMagic = []byte("ARIMADLT")
+	// ChainMagic starts a snapshot stream made of several segments, a full
+	// snapshot followed by the changes of each incremental snapshot on top of it.
+	ChainMagic = []byte("ARIMACHN")
+	// LegacyMagic starts a snapshot stream some segments of which may predate
+	// the snapshot envelope of the FSM. Open adds it to the stream of snapshots
+	// whose metadata does not record the envelope, so bare segments are only
+	// accepted from them.
+	LegacyMagic = []byte("ARIMALGC")
+)
+
+// envelopeField marks the metadata of snapshots written since the FSM wraps
+// every snapshot in an envelope.
+const envelopeField = "Envelope"
+
+// SnapshotStore is a file snapshot store that understands incremental snapshots.
+// Open expands an incremental snapshot into the chain of segments it depends
+// on, so raft restores and sends self-contained streams.
+type SnapshotStore struct {
+	*raft.FileSnapshotStore
+	// dir is where the file snapshot store keeps the snapshots.
+	dir string
+}
+
+// NewSnapshotStore opens the snapshot store under dir. Retention is widened by
+// chainLength-1 so the chain of the newest snapshot is never reaped.
+func NewSnapshotStore(dir string, retain, chainLength int, logOutput io.Writer) (*SnapshotStore, error) {
+	if chainLength > 1 {
+		retain += chainLength - 1
+	}
+	fss, err := raft.NewFileSnapshotStore(dir, retain, logOutput)
+	if err != nil {
+		return nil, err
+	}
+	return &SnapshotStore{FileSnapshotStore: fss, dir: filepath.Join(dir, "snapshots")}, nil
+}
+
+// Create creates a snapshot whose metadata records the envelope once it is
+// closed.
+func (s *SnapshotStore) Create(version raft.SnapshotVersion, index, term uint64, configuration raft.Configuration,
+	configurationIndex uint64, trans raft.Transport) (raft.SnapshotSink, error) {
+	sink, err := s.FileSnapshotStore.Create(version, index, term, configuration, configurationIndex, trans)
+	if err != nil {
+		return nil, err
+	}
+	return &envelopeSink{SnapshotSink: sink, dir: s.dir}, nil
+}
+
+type envelopeSink struct {
+	raft.SnapshotSink
+	dir string
+}
+
+// Close persists the snapshot and marks its metadata. A snapshot left unmarked
+// is opened as a legacy one, which only loosens its verification, so failing to
+// mark it is not an error.
+func (e *envelopeSink) Close() error {
+	if err := e.SnapshotSink.Close(); err != nil {
+		return err
+	}
+	if err := markEnvelope(filepath.Join(e.dir, e.ID(), "meta.json")); err != nil {
+		log.Printf("error marking the metadata of snapshot %s: %s", e.ID(), err)
+	}
+	return nil
+}
+
+// markEnvelope records the envelope in the metadata of a snapshot, keeping the
+// fields the file snapshot store wrote.
+func markEnvelope(metaPath string) error {
+	data, err := ioutil.ReadFile(metaPath)
+	if err != nil {
+		return err
+	}
+	meta := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return err
+	}
+	meta[envelopeField] = json.RawMessage("true")
+	if data, err = json.Marshal(meta); err != nil {
+		return err
+	}
+
+	tmpPath := metaPath + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, metaPath)
+}
+
+// hasEnvelope reports whether the metadata of a snapshot records the envelope.
+func (s *SnapshotStore) hasEnvelope(id string) bool {
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, id, "meta.json"))
+	if err != nil {
+		return false
+	}
+	var meta map[string]json.RawMessage
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return false
+	}
+	return string(meta[envelopeField]) == "true"
+}
+
+// segment is the state of one snapshot of a chain.
+type segment struct {
+	r    *bufio.Reader
+	size int64
+}
+
+// Open opens a snapshot. Incremental snapshots are returned as a chain stream
+// and their size is that of the whole chain. The stream starts with LegacyMagic
+// when any snapshot of the chain may predate the envelope.
+func (s *SnapshotStore) Open(id string) (*raft.SnapshotMeta, io.ReadCloser, error) {
+	var (
+		meta     *raft.SnapshotMeta
+		segments []segment
+		closers  multiCloser
+		legacy   bool
+	)
+	for {
+		m, rc, err := s.FileSnapshotStore.Open(id)
+		if err != nil {
+			closers.Close()
+			if meta != nil {
+				return nil, nil, fmt.Errorf("error opening snapshot %s of the chain of %s: %s", id, meta.ID, err)
+			}
+			return nil, nil, err
+		}
+		closers = append(closers, rc)
+		if meta == nil {
+			meta = m
+		}
+
+		br := bufio.NewReader(rc)
+		size := m.Size
+		// Snapshots installed from a leader keep the mark of the stream they
+		// were sent as.
+		marked, err := ReadLegacyMagic(br)
+		if err != nil {
+			closers.Close()
+			return nil, nil, err
+		}
+		if marked {
+			size -= int64(len(LegacyMagic))
+		}
+		legacy = legacy || marked || !s.hasEnvelope(id)
+
+		parent, ok, err := ReadDeltaHeader(br)
+		if err != nil {
+			closers.Close()
+			return nil, nil, err
+		}
+		if !ok {
+			segments = append(segments, segment{r: br, size: size})
+			break
+		}
+		segments = append(segments, segment{r: br, size: size - deltaHeaderSize(parent)})
+		id = parent
+	}
+
+	var (
+		readers []io.Reader
+		size    int64
+	)
+	if legacy {
+		readers = append(readers, bytes.NewReader(LegacyMagic))
+		size += int64(len(LegacyMagic))
+	}
+	if len(segments) == 1 {
+		expanded := *meta
+		expanded.Size = size + segments[0].size
+		readers = append(readers, segments[0].r)
+		return &expanded, readCloser{io.MultiReader(readers...), closers}, nil
+	}
+
+	readers = append(readers, bytes.NewReader(ChainMagic))
+	size += int64(len(ChainMagic))
+
+	// The base is either a full snapshot or, on nodes that installed a snapshot
+	// from the leader, a chain whose segments are taken over.
+	base := segments[len(segments)-1]
+	if IsChain(base.r) {
+		if _, err := base.r.Discard(len(ChainMagic)); err != nil {
+			closers.Close()
+			return nil, nil, err
+		}
+		readers = append(readers, io.LimitReader(base.r, base.size-int64(len(ChainMagic))))
+		size += base.size - int64(len(ChainMagic))
+	} else {
+		readers = append(readers, segmentHeader(base.size), io.LimitReader(base.r, base.size))
+		size += 8 + base.size
+	}
+	for i := len(segments) - 2; i >= 0; i-- {
+		seg := segments[i]
+		readers = append(readers, segmentHeader(seg.size), io.LimitReader(seg.r, seg.size))
+		size += 8 + seg.size
+	}
+
+	expanded := *meta
+	expanded.Size = size
+	return &expanded, readCloser{io.MultiReader(readers...), closers}, nil
+}
+
+// WriteDeltaHeader starts the state of an incremental snapshot building on the
+// snapshot parentID.
+func WriteDeltaHeader(w io.Writer, parentID string) error {
+	if _, err := w.Write(DeltaMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, uint16(len(parentID))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, parentID)
+	return err
+}
+
+// ReadDeltaHeader reads the header of an incremental snapshot, returning the
+// ID of its parent. ok is false, and nothing is read, for other snapshots.
+func ReadDeltaHeader(r *bufio.Reader) (parentID string, ok bool, err error) {
+	if !hasMagic(r, DeltaMagic) {
+		return "", false, nil
+	}
+	if _, err := r.Discard(len(DeltaMagic)); err != nil {
+		return "", false, err
+	}
+	var n uint16
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return "", false, fmt.Errorf("error reading incremental snapshot header: %s", err)
+	}
+	id := make([]byte, n)
+	if _, err := io.ReadFull(r, id); err != nil {
+		return "", false, fmt.Errorf("error reading incremental snapshot header: %s", err)
+	}
+	return string(id), true, nil
+}
+
+func deltaHeaderSize(parentID string) int64 {
+	return int64(len(DeltaMagic) + 2 + len(parentID))
+}
+
+// ReadLegacyMagic reports whether the stream starts with LegacyMagic, which it
+// consumes.
+func ReadLegacyMagic(r *bufio.Reader) (bool, error) {
+	if !hasMagic(r, LegacyMagic) {
+		return false, nil
+	}
+	_, err := r.Discard(len(LegacyMagic))
+	return err == nil, err
+}
+
+// IsChain reports whether the buffered stream is a chain of segments, without
+// consuming anything.
+func IsChain(r *bufio.Reader) bool {
+	return hasMagic(r, ChainMagic)
+}
+
+// ReadSegments calls fn with every segment of a snapshot stream in order. A
+// stream that is not a chain is a single segment.
+func ReadSegments(r *bufio.Reader, fn func(segment *bufio.Reader) error) error {
+	if hasMagic(r, DeltaMagic) {
+		return errors.New("incremental snapshot opened without its chain")
+	}
+	if !IsChain(r) {
+		return fn(r)
+	}
+	if _, err := r.Discard(len(ChainMagic)); err != nil {
+		return err
+	}
+	for {
+		var size uint64
+		err := binary.Read(r, binary.BigEndian, &size)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading snapshot segment: %s", err)
+		}
+		seg := io.LimitReader(r, int64(size))
+		if err := fn(bufio.NewReader(seg)); err != nil {
+			return err
+		}
+		// Whatever the segment reader left unread belongs to this segment.
+		if _, err := io.Copy(ioutil.Discard, seg); err != nil {
+			return err
+		}
+	}
+}
+
+// WriteSegment appends a segment of size bytes to a chain stream, which must
+// have been started with ChainMagic.
+func WriteSegment(w io.Writer, r io.Reader, size int64) error {
+	if err := binary.Write(w, binary.BigEndian, uint64(size)); err != nil {
+		return err
+	}
+	n, err := io.Copy(w, r)
+	if err == nil && n != size {
+		err = fmt.Errorf("snapshot segment is %d bytes, expected %d", n, size)
+	}
+	return err
+}
+
+func segmentHeader(size int64) io.Reader {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(size))
+	return bytes.NewReader(buf[:])
+}
+
+func hasMagic(r *bufio.Reader, magic []byte) bool {
+	head, err := r.Peek(len(magic))
+	return err == nil && bytes.Equal(head, magic)
+}
+
+type multiCloser []io.Closer
+
+func (m multiCloser) Close() error {
+	var first error
+	for _, c := range m {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
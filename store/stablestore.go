@@ -11,27 +11,26 @@ var ErrKeyNotFound = errors.New("not found")
 
 type StableStore struct {
 	Conn *badger.DB
+	view *View
+	// shared is set when the database also holds other stores, which close it.
+	shared bool
 }
 
-func NewStableStore(path string) (*StableStore, error) {
-	var err error
-	opts := badger.DefaultOptions(path)
-	opts.Logger = nil
-	opts.SyncWrites = true
-
-	handle, err := badger.Open(opts)
+func NewStableStore(path string, opts Options) (*StableStore, error) {
+	handle, err := badger.Open(opts.BadgerOptions(path))
 	if err != nil {
 		return nil, err
 	}
 
 	return &StableStore{
 		Conn: handle,
+		view: NewView(handle, nil),
 	}, nil
 }
 
 // Set is used to set a key/value set
 func (store *StableStore) Set(key, val []byte) error {
-	return store.Conn.Update(func(txn *badger.Txn) error {
+	return store.view.Update(func(txn *ViewTxn) error {
 		err := txn.Set(key, val)
 		if err != nil {
 			return err
@@ -43,7 +42,7 @@ func (store *StableStore) Set(key, val []byte) error {
 // Get returns the value for key, or an empty byte slice if key was not found.
 func (store *StableStore) Get(key []byte) ([]byte, error) {
 	var value []byte
-	err := store.Conn.View(func(txn *badger.Txn) error {
+	err := store.view.View(func(txn *ViewTxn) error {
 		item, err := txn.Get(key)
 		if err != nil {
 			if err.Error() == badger.ErrKeyNotFound.Error() {
@@ -74,3 +73,20 @@ func (store *StableStore) GetUint64(key []byte) (uint64, error) {
 	}
 	return utils.BytesToUint64(val), nil
 }
+
+// Ping checks the store is open and can sync to disk. Nothing is written, so
+// frequent readiness probes cost raft's store no writes.
+func (store *StableStore) Ping() error {
+	if store.Conn.IsClosed() {
+		return errors.New("stable store is closed")
+	}
+	return store.Conn.Sync()
+}
+
+// Close closes the badger database, unless it is shared.
+func (store *StableStore) Close() error {
+	if store.shared {
+		return nil
+	}
+	return store.Conn.Close()
+}
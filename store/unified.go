@@ -0,0 +1,236 @@
+package store
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// UnifiedDir is the directory of the unified database in a volume.
+const UnifiedDir = "data"
+
+// Key prefixes of the unified database. The FSM is stored under a generation,
+// so a restored snapshot is loaded under the next one and swapped in by
+// updating fsmGenerationKey.
+var (
+	logPrefix        = []byte("l/")
+	stablePrefix     = []byte("s/")
+	fsmPrefix        = []byte("f/")
+	fsmGenerationKey = []byte("m/fsm-generation")
+)
+
+// Unified is a single badger database holding the raft log, the stable store
+// and the FSM, partitioned by key prefix. Log entries, votes and FSM writes
+// share one write-ahead log and one sync.
+type Unified struct {
+	Conn *badger.DB
+	dir  string
+}
+
+// OpenUnified opens the unified database at path.
+func OpenUnified(path string, opts Options) (*Unified, error) {
+	if err := os.RemoveAll(path + spoolSuffix); err != nil {
+		return nil, err
+	}
+	handle, err := badger.Open(opts.BadgerOptions(path))
+	if err != nil {
+		return nil, err
+	}
+	return &Unified{Conn: handle, dir: path}, nil
+}
+
+// LogStore returns the raft log of the database.
+func (u *Unified) LogStore() (*LogStore, error) {
+	return newLogStore(NewView(u.Conn, logPrefix), true)
+}
+
+// StableStore returns the stable store of the database.
+func (u *Unified) StableStore() *StableStore {
+	return &StableStore{
+		Conn:   u.Conn,
+		view:   NewView(u.Conn, stablePrefix),
+		shared: true,
+	}
+}
+
+// FSMStore returns the FSM store of the database, dropping what a restore
+// interrupted by a crash left behind.
+func (u *Unified) FSMStore() (*UnifiedFSMStore, error) {
+	var generation uint64
+	err := u.Conn.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(fsmGenerationKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			generation = binary.BigEndian.Uint64(val)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading fsm generation: %s", err)
+	}
+
+	s := &UnifiedFSMStore{unified: u, generation: generation}
+	if err := s.generationView(generation + 1).DropAll(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Size returns the LSM and value log sizes of the database.
+func (u *Unified) Size() (lsm, vlog int64) {
+	return u.Conn.Size()
+}
+
+// IsClosed reports whether the database is closed.
+func (u *Unified) IsClosed() bool {
+	return u.Conn.IsClosed()
+}
+
+// Close closes the badger database.
+func (u *Unified) Close() error {
+	return u.Conn.Close()
+}
+
+// UnifiedFSMStore keeps the FSM in the unified database.
+type UnifiedFSMStore struct {
+	unified    *Unified
+	generation uint64
+}
+
+func (s *UnifiedFSMStore) generationView(generation uint64) *View {
+	prefix := make([]byte, len(fsmPrefix)+8)
+	copy(prefix, fsmPrefix)
+	binary.BigEndian.PutUint64(prefix[len(fsmPrefix):], generation)
+	return NewView(s.unified.Conn, prefix)
+}
+
+// View returns the current generation.
+func (s *UnifiedFSMStore) View() Engine {
+	return NewBadgerEngine(s.generationView(s.generation))
+}
+
+// Stage returns the next generation, emptied first.
+func (s *UnifiedFSMStore) Stage() (Engine, error) {
+	staged := s.generationView(s.generation + 1)
+	if err := staged.DropAll(); err != nil {
+		return nil, err
+	}
+	return NewBadgerEngine(staged), nil
+}
+
+// Abort drops the next generation.
+func (s *UnifiedFSMStore) Abort() error {
+	return s.generationView(s.generation + 1).DropAll()
+}
+
+// Commit makes the next generation the current one, in a single transaction,
+// and drops the previous one.
+func (s *UnifiedFSMStore) Commit() error {
+	next := s.generation + 1
+	val := make([]byte, 8)
+	binary.BigEndian.PutUint64(val, next)
+	err := s.unified.Conn.Update(func(txn *badger.Txn) error {
+		return txn.Set(fsmGenerationKey, val)
+	})
+	if err != nil {
+		return fmt.Errorf("error swapping in restored snapshot, keeping the current state: %s", err)
+	}
+
+	previous := s.generationView(s.generation)
+	s.generation = next
+	if err := previous.DropAll(); err != nil {
+		log.Printf("error dropping the previous fsm state: %s", err)
+	}
+	return nil
+}
+
+// SpoolPath returns the path next to the database directory.
+func (s *UnifiedFSMStore) SpoolPath() string {
+	return s.unified.dir + spoolSuffix
+}
+
+// Close does nothing, the unified database is closed on its own.
+func (s *UnifiedFSMStore) Close() error {
+	return nil
+}
+
+// MigrateToUnified copies the separate FSM, log and stable databases into a
+// new unified database at path. The copy is built next to path and renamed
+// into place once complete, so an interrupted migration starts over.
+func MigrateToUnified(path, fsmPath, logPath, stablePath string, opts Options) error {
+	staging := path + ".migrate"
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+	u, err := OpenUnified(staging, opts)
+	if err != nil {
+		return err
+	}
+
+	fsmStore, err := u.FSMStore()
+	if err != nil {
+		u.Close()
+		return err
+	}
+	for _, part := range []struct {
+		src string
+		dst *View
+	}{
+		{fsmPath, fsmStore.generationView(fsmStore.generation)},
+		{logPath, NewView(u.Conn, logPrefix)},
+		{stablePath, NewView(u.Conn, stablePrefix)},
+	} {
+		if _, err := os.Stat(part.src); os.IsNotExist(err) {
+			continue
+		}
+		if err := copyInto(part.src, part.dst, opts); err != nil {
+			u.Close()
+			return fmt.Errorf("error migrating %s: %s", part.src, err)
+		}
+	}
+	if err := u.Close(); err != nil {
+		return err
+	}
+	return os.Rename(staging, path)
+}
+
+// copyInto copies the live keys of the database at src into dst.
+func copyInto(src string, dst *View, opts Options) error {
+	db, err := badger.Open(opts.BadgerOptions(src))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	batch := dst.NewWriteBatch()
+	defer batch.Cancel()
+	err = NewView(db, nil).View(func(txn *ViewTxn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			e := badger.NewEntry(item.KeyCopy(nil), val).WithMeta(item.UserMeta())
+			e.ExpiresAt = item.ExpiresAt()
+			if err := batch.SetEntry(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return batch.Flush()
+}
@@ -0,0 +1,210 @@
+package store
+
+import (
+	"bytes"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// View is the part of a badger database under a key prefix. A store with its
+// own database uses an empty prefix. Keys passed to and returned by a view
+// never include the prefix.
+type View struct {
+	db     *badger.DB
+	prefix []byte
+}
+
+// NewView returns the view of db under prefix.
+func NewView(db *badger.DB, prefix []byte) *View {
+	return &View{db: db, prefix: prefix}
+}
+
+// DB returns the database the view is part of.
+func (v *View) DB() *badger.DB {
+	return v.db
+}
+
+func (v *View) key(key []byte) []byte {
+	if len(v.prefix) == 0 {
+		return key
+	}
+	k := make([]byte, 0, len(v.prefix)+len(key))
+	return append(append(k, v.prefix...), key...)
+}
+
+// NewTransaction starts a transaction. Discard must be called once done.
+func (v *View) NewTransaction(update bool) *ViewTxn {
+	return &ViewTxn{Txn: v.db.NewTransaction(update), view: v}
+}
+
+// View runs fn in a read-only transaction.
+func (v *View) View(fn func(txn *ViewTxn) error) error {
+	return v.db.View(func(txn *badger.Txn) error {
+		return fn(&ViewTxn{Txn: txn, view: v})
+	})
+}
+
+// Update runs fn in a read-write transaction.
+func (v *View) Update(fn func(txn *ViewTxn) error) error {
+	return v.db.Update(func(txn *badger.Txn) error {
+		return fn(&ViewTxn{Txn: txn, view: v})
+	})
+}
+
+// DropPrefix deletes every key of the view starting with prefix.
+func (v *View) DropPrefix(prefix []byte) error {
+	return v.db.DropPrefix(v.key(prefix))
+}
+
+// DropAll deletes every key of the view.
+func (v *View) DropAll() error {
+	if len(v.prefix) == 0 {
+		return v.db.DropAll()
+	}
+	return v.db.DropPrefix(v.prefix)
+}
+
+// NewWriteBatch starts a write batch, which commits as many transactions as
+// needed and is therefore not atomic.
+func (v *View) NewWriteBatch() *WriteBatch {
+	return &WriteBatch{wb: v.db.NewWriteBatch(), view: v}
+}
+
+// ViewTxn is a transaction of a view. The embedded badger transaction gives access
+// to its timestamps, commit and discard.
+type ViewTxn struct {
+	*badger.Txn
+	view *View
+}
+
+// Get looks up a key.
+func (t *ViewTxn) Get(key []byte) (*ViewItem, error) {
+	item, err := t.Txn.Get(t.view.key(key))
+	if err != nil {
+		return nil, err
+	}
+	return &ViewItem{Item: item, prefix: len(t.view.prefix)}, nil
+}
+
+// Set sets a key.
+func (t *ViewTxn) Set(key, value []byte) error {
+	return t.Txn.Set(t.view.key(key), value)
+}
+
+// Delete deletes a key.
+func (t *ViewTxn) Delete(key []byte) error {
+	return t.Txn.Delete(t.view.key(key))
+}
+
+// NewIterator iterates over the keys of the view, restricted to opts.Prefix
+// when set.
+func (t *ViewTxn) NewIterator(opts badger.IteratorOptions) *ViewIterator {
+	opts.Prefix = t.view.key(opts.Prefix)
+	return &ViewIterator{
+		it:      t.Txn.NewIterator(opts),
+		prefix:  t.view.prefix,
+		scope:   opts.Prefix,
+		reverse: opts.Reverse,
+	}
+}
+
+// ViewIterator iterates over the keys of a view.
+type ViewIterator struct {
+	it      *badger.Iterator
+	prefix  []byte
+	scope   []byte
+	reverse bool
+}
+
+// Rewind moves to the first key, or the last one when iterating in reverse.
+func (i *ViewIterator) Rewind() {
+	if !i.reverse || len(i.scope) == 0 {
+		i.it.Rewind()
+		return
+	}
+	// Reverse iteration starts from the last key of the scope, which sorts
+	// before any key longer than the scope followed by 0xff bytes.
+	i.it.Seek(append(append([]byte{}, i.scope...), bytes.Repeat([]byte{0xff}, 16)...))
+}
+
+// Seek moves to the first key at or after key, at or before it in reverse.
+func (i *ViewIterator) Seek(key []byte) {
+	k := make([]byte, 0, len(i.prefix)+len(key))
+	i.it.Seek(append(append(k, i.prefix...), key...))
+}
+
+// Valid reports whether the iterator is on a key of its scope.
+func (i *ViewIterator) Valid() bool {
+	return i.it.ValidForPrefix(i.scope)
+}
+
+// ValidForPrefix reports whether the iterator is on a key starting with prefix.
+func (i *ViewIterator) ValidForPrefix(prefix []byte) bool {
+	k := make([]byte, 0, len(i.prefix)+len(prefix))
+	return i.it.ValidForPrefix(append(append(k, i.prefix...), prefix...))
+}
+
+// Next moves to the next key.
+func (i *ViewIterator) Next() {
+	i.it.Next()
+}
+
+// Item returns the current item.
+func (i *ViewIterator) Item() *ViewItem {
+	return &ViewItem{Item: i.it.Item(), prefix: len(i.prefix)}
+}
+
+// Close closes the iterator.
+func (i *ViewIterator) Close() {
+	i.it.Close()
+}
+
+// ViewItem is a key of a view. The embedded badger item gives access to its value
+// and metadata.
+type ViewItem struct {
+	*badger.Item
+	prefix int
+}
+
+// Key returns the key without the view's prefix. It is only valid until the
+// iterator moves.
+func (i *ViewItem) Key() []byte {
+	return i.Item.Key()[i.prefix:]
+}
+
+// KeyCopy returns a copy of the key without the view's prefix.
+func (i *ViewItem) KeyCopy(dst []byte) []byte {
+	return append(dst[:0], i.Key()...)
+}
+
+// WriteBatch batches writes to a view.
+type WriteBatch struct {
+	wb   *badger.WriteBatch
+	view *View
+}
+
+// Set sets a key.
+func (w *WriteBatch) Set(key, value []byte) error {
+	return w.wb.Set(w.view.key(key), value)
+}
+
+// SetEntry sets an entry, whose key is relative to the view.
+func (w *WriteBatch) SetEntry(e *badger.Entry) error {
+	e.Key = w.view.key(e.Key)
+	return w.wb.SetEntry(e)
+}
+
+// Delete deletes a key.
+func (w *WriteBatch) Delete(key []byte) error {
+	return w.wb.Delete(w.view.key(key))
+}
+
+// Flush commits the pending writes.
+func (w *WriteBatch) Flush() error {
+	return w.wb.Flush()
+}
+
+// Cancel discards the pending writes.
+func (w *WriteBatch) Cancel() {
+	w.wb.Cancel()
+}
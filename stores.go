@@ -0,0 +1,223 @@
+package arima
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/raft"
+	"github.com/rohankmr414/arima/fsm"
+	"github.com/rohankmr414/arima/metrics"
+	"github.com/rohankmr414/arima/server/health_handler"
+	"github.com/rohankmr414/arima/store"
+)
+
+// Stores are the stores of a node: three badger databases, a single unified
+// one, or nothing on disk at all.
+type Stores struct {
+	FSM    *fsm.ArimaFSM
+	Log    raft.LogStore
+	Stable raft.StableStore
+	// databases are the badger databases whose sizes are reported.
+	databases map[string]metrics.BadgerDB
+	// pingers are checked by the readiness check.
+	pingers map[string]health_handler.Pinger
+	closers []io.Closer
+}
+
+// Close closes the stores.
+func (s *Stores) Close() error {
+	var closeErr error
+	for _, c := range s.closers {
+		if err := c.Close(); err != nil {
+			closeErr = err
+		}
+	}
+	return closeErr
+}
+
+// OpenStores opens the stores of a volume. With separate databases, the FSM is
+// kept at the root of the volume. A volume that already uses the unified layout
+// keeps it; with unified storage configured, a volume using separate databases
+// is migrated to it first.
+func OpenStores(volume string, conf StorageOptions) (*Stores, error) {
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	opts := conf.Options
+
+	dataPath := filepath.Join(volume, store.UnifiedDir)
+	separate := []string{volume, filepath.Join(volume, "log"), filepath.Join(volume, "stable")}
+
+	_, err := os.Stat(dataPath)
+	unified := err == nil
+	if (unified || conf.UnifiedStorage) && conf.Engine == "memory" {
+		return nil, errors.New("unified storage keeps the FSM in badger, it cannot be combined with the memory engine")
+	}
+
+	if unified {
+		// The separate databases are removed once migrated, a crash may have
+		// left them behind.
+		if err := removeSeparate(separate); err != nil {
+			return nil, err
+		}
+		return openUnified(dataPath, opts)
+	}
+	if !conf.UnifiedStorage {
+		return openSeparate(separate, opts, conf.Engine)
+	}
+
+	if _, err := os.Stat(separate[1]); err == nil {
+		log.Printf("Migrating %s to a unified database in %s", volume, dataPath)
+		if err := store.RecoverRootFSM(volume); err != nil {
+			return nil, err
+		}
+		if err := store.MigrateToUnified(dataPath, separate[0], separate[1], separate[2], opts); err != nil {
+			return nil, err
+		}
+		if err := removeSeparate(separate); err != nil {
+			return nil, err
+		}
+		log.Printf("Migrated %s to a unified database", volume)
+	}
+	return openUnified(dataPath, opts)
+}
+
+// removeSeparate removes the separate databases of a volume: the FSM at its
+// root and the log and stable directories.
+func removeSeparate(dirs []string) error {
+	if err := store.RemoveRootFSM(dirs[0]); err != nil {
+		return err
+	}
+	for _, dir := range dirs[1:] {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func openUnified(path string, opts store.Options) (*Stores, error) {
+	u, err := store.OpenUnified(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	stable := u.StableStore()
+	logStore, err := u.LogStore()
+	if err != nil {
+		u.Close()
+		return nil, err
+	}
+	s := &Stores{
+		Log:       logStore,
+		Stable:    stable,
+		databases: map[string]metrics.BadgerDB{"data": u},
+	}
+	data, err := u.FSMStore()
+	if err != nil {
+		u.Close()
+		return nil, err
+	}
+	if s.FSM, err = fsm.NewArimaFSMWithStore(data, opts); err != nil {
+		u.Close()
+		return nil, err
+	}
+	// The FSM waits for its snapshots before the database is closed.
+	s.closers = []io.Closer{s.FSM, u}
+	s.pingers = map[string]health_handler.Pinger{"fsm": s.FSM, "log": logStore, "stable": stable}
+	return s, nil
+}
+
+func openSeparate(dirs []string, opts store.Options, engine string) (*Stores, error) {
+	s := &Stores{}
+	var err error
+	if engine == "memory" {
+		s.FSM, err = fsm.NewArimaFSMWithStore(store.NewMemoryFSMStore(), opts)
+	} else {
+		s.FSM, err = openRootFSM(dirs[0], opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.closers = append(s.closers, s.FSM)
+	logStore, err := store.NewLogStore(dirs[1], opts)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.Log = logStore
+	s.closers = append(s.closers, logStore)
+	stable, err := store.NewStableStore(dirs[2], opts)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.Stable = stable
+	s.closers = append(s.closers, stable)
+	s.databases = map[string]metrics.BadgerDB{
+		"log":    logStore.Conn,
+		"stable": stable.Conn,
+	}
+	s.pingers = map[string]health_handler.Pinger{"fsm": s.FSM, "log": logStore, "stable": stable}
+	if engine != "memory" {
+		s.databases["fsm"] = s.FSM
+	}
+	return s, nil
+}
+
+// openRootFSM opens the FSM kept at the root of volume.
+func openRootFSM(volume string, opts store.Options) (*fsm.ArimaFSM, error) {
+	data, err := store.NewRootFSMStore(volume, opts)
+	if err != nil {
+		return nil, err
+	}
+	arimaFsm, err := fsm.NewArimaFSMWithStore(data, opts)
+	if err != nil {
+		data.Close()
+		return nil, err
+	}
+	return arimaFsm, nil
+}
+
+// MemoryStores are the raft log, stable store and snapshots of an in-memory
+// node. A node started with the stores of a stopped one resumes with its data,
+// rebuilding its FSM from them.
+type MemoryStores struct {
+	Log       *raft.InmemStore
+	Snapshots *raft.InmemSnapshotStore
+}
+
+// NewMemoryStores returns empty stores.
+func NewMemoryStores() *MemoryStores {
+	return &MemoryStores{
+		Log:       raft.NewInmemStore(),
+		Snapshots: raft.NewInmemSnapshotStore(),
+	}
+}
+
+// openInMemoryStores keeps the FSM in memory, next to the raft stores of mem.
+func openInMemoryStores(opts store.Options, mem *MemoryStores) (*Stores, error) {
+	arimaFsm, err := fsm.NewArimaFSMWithStore(store.NewMemoryFSMStore(), opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Stores{
+		FSM:       arimaFsm,
+		Log:       mem.Log,
+		Stable:    mem.Log,
+		databases: map[string]metrics.BadgerDB{},
+		pingers:   map[string]health_handler.Pinger{"fsm": arimaFsm},
+		closers:   []io.Closer{arimaFsm},
+	}, nil
+}
+
+// StoreDirs returns the badger directories of a volume.
+func StoreDirs(volume string) ([]string, error) {
+	dataPath := filepath.Join(volume, store.UnifiedDir)
+	if _, err := os.Stat(dataPath); err == nil {
+		return []string{dataPath}, nil
+	}
+	return []string{volume, filepath.Join(volume, "log"), filepath.Join(volume, "stable")}, nil
+}
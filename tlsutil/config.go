@@ -0,0 +1,94 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// ServerConfig returns a TLS configuration for listeners that always serves the
+// current certificate. With requireClientCert, clients must present a
+// certificate signed by the current CA bundle.
+func (r *Reloader) ServerConfig(requireClientCert bool) *tls.Config {
+	conf := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return r.certificate(), nil
+		},
+	}
+	if requireClientCert {
+		conf.ClientAuth = tls.RequireAnyClientCert
+		conf.VerifyConnection = func(cs tls.ConnectionState) error {
+			_, err := r.verifyPeer(cs)
+			return err
+		}
+	}
+	return conf
+}
+
+// ClientConfig returns a TLS configuration for dialing a peer that presents the
+// current certificate. When identity is not empty the peer certificate must carry
+// it, as its common name or as a DNS subject alternative name.
+func (r *Reloader) ClientConfig(identity string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// The chain and the identity are verified by VerifyConnection against the
+		// current CA bundle, which may change after the config was created.
+		InsecureSkipVerify: true,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return r.certificate(), nil
+		},
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			cert, err := r.verifyPeer(cs)
+			if err != nil {
+				return err
+			}
+			if identity != "" && !HasIdentity(cert, identity) {
+				return fmt.Errorf("peer certificate does not belong to %s", identity)
+			}
+			return nil
+		},
+	}
+}
+
+// verifyPeer verifies the certificate chain presented by the peer.
+func (r *Reloader) verifyPeer(cs tls.ConnectionState) (*x509.Certificate, error) {
+	if len(cs.PeerCertificates) == 0 {
+		return nil, errors.New("peer presented no certificate")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         r.CertPool(),
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := cs.PeerCertificates[0].Verify(opts); err != nil {
+		return nil, err
+	}
+	return cs.PeerCertificates[0], nil
+}
+
+// HasIdentity reports whether the certificate was issued to identity.
+func HasIdentity(cert *x509.Certificate, identity string) bool {
+	if cert.Subject.CommonName == identity {
+		return true
+	}
+	for _, name := range cert.DNSNames {
+		if name == identity {
+			return true
+		}
+	}
+	return false
+}
+
+// Identities returns every identity the certificate was issued to.
+func Identities(cert *x509.Certificate) []string {
+	ids := append([]string{}, cert.DNSNames...)
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, cert.Subject.CommonName)
+	}
+	return ids
+}
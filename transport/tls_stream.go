@@ -0,0 +1,204 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/rohankmr414/arima/tlsutil"
+)
+
+const (
+	// handshakeTimeout bounds the TLS handshake of accepted raft connections.
+	handshakeTimeout = 10 * time.Second
+
+	// maxAcceptDelay caps the backoff between retries of temporary accept errors.
+	maxAcceptDelay = time.Second
+)
+
+// TLSStreamLayer implements raft.StreamLayer with mutual TLS. Both sides present
+// certificates signed by the shared CA, issued to a node ID of the configuration.
+// Only members of the configuration are dialed, and a dialed peer must present
+// the certificate of the member at that address. A node alone in its
+// configuration, waiting to be joined, accepts any node ID signed by the CA.
+type TLSStreamLayer struct {
+	listener  net.Listener
+	advertise net.Addr
+	certs     *tlsutil.Reloader
+
+	accepted  chan net.Conn
+	acceptErr chan error
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu    sync.RWMutex
+	peers map[raft.ServerAddress]raft.ServerID
+	ids   map[string]bool
+}
+
+// NewTLSStreamLayer listens on bindAddr and advertises advertise to peers.
+func NewTLSStreamLayer(bindAddr string, advertise net.Addr, certs *tlsutil.Reloader) (*TLSStreamLayer, error) {
+	if certs.CertPool() == nil {
+		return nil, errors.New("raft mutual TLS requires a CA file")
+	}
+
+	listener, err := tls.Listen("tcp", bindAddr, certs.ServerConfig(true))
+	if err != nil {
+		return nil, err
+	}
+
+	if advertise == nil {
+		advertise = listener.Addr()
+	}
+	addr, ok := advertise.(*net.TCPAddr)
+	if !ok || addr.IP == nil || addr.IP.IsUnspecified() {
+		listener.Close()
+		return nil, fmt.Errorf("local bind address is not advertisable: %v", advertise)
+	}
+
+	t := &TLSStreamLayer{
+		listener:  listener,
+		advertise: advertise,
+		certs:     certs,
+		accepted:  make(chan net.Conn),
+		acceptErr: make(chan error, 1),
+		closed:    make(chan struct{}),
+		peers:     map[raft.ServerAddress]raft.ServerID{},
+		ids:       map[string]bool{},
+	}
+	go t.acceptLoop()
+	return t, nil
+}
+
+// UpdatePeers records the address and node ID of every server in the
+// configuration, which dialed and accepted peers are verified against.
+func (t *TLSStreamLayer) UpdatePeers(configuration raft.Configuration) {
+	peers := make(map[raft.ServerAddress]raft.ServerID, len(configuration.Servers))
+	ids := make(map[string]bool, len(configuration.Servers))
+	for _, server := range configuration.Servers {
+		peers[server.Address] = server.ID
+		ids[string(server.ID)] = true
+	}
+	t.mu.Lock()
+	t.peers = peers
+	t.ids = ids
+	t.mu.Unlock()
+}
+
+// Dial implements the raft.StreamLayer interface. It refuses addresses that are
+// not in the configuration.
+func (t *TLSStreamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	t.mu.RLock()
+	id, ok := t.peers[address]
+	t.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("error dialing %s: not a member of the configuration", address)
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", string(address), t.certs.ClientConfig(string(id)))
+	if err != nil {
+		return nil, fmt.Errorf("error dialing %s: %s", address, err)
+	}
+	return conn, nil
+}
+
+// Accept implements the net.Listener interface. Handshakes are completed before a
+// connection is handed to raft, so unauthenticated peers never reach it.
+func (t *TLSStreamLayer) Accept() (net.Conn, error) {
+	select {
+	case conn := <-t.accepted:
+		return conn, nil
+	case err := <-t.acceptErr:
+		return nil, err
+	case <-t.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// acceptLoop accepts connections and completes their handshakes concurrently, so a
+// slow peer cannot hold up the others. Temporary errors, such as running out of
+// file descriptors, are retried with a backoff.
+func (t *TLSStreamLayer) acceptLoop() {
+	var delay time.Duration
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > maxAcceptDelay {
+					delay = maxAcceptDelay
+				}
+				log.Printf("error accepting raft connection, retrying in %s: %s", delay, err)
+				select {
+				case <-time.After(delay):
+					continue
+				case <-t.closed:
+				}
+			}
+			t.acceptErr <- err
+			return
+		}
+		delay = 0
+		go t.handshake(conn.(*tls.Conn))
+	}
+}
+
+func (t *TLSStreamLayer) handshake(conn *tls.Conn) {
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := conn.Handshake(); err != nil {
+		log.Printf("rejected raft connection from %s: %s", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	peer := conn.ConnectionState().PeerCertificates[0]
+	if !t.member(peer) {
+		log.Printf("rejected raft connection from %s: certificate issued to %v, not to a member of the configuration", conn.RemoteAddr(), tlsutil.Identities(peer))
+		conn.Close()
+		return
+	}
+	select {
+	case t.accepted <- conn:
+	case <-t.closed:
+		conn.Close()
+	}
+}
+
+// member reports whether the certificate was issued to a node ID of the
+// configuration, or to any node ID while the node is alone in it.
+func (t *TLSStreamLayer) member(cert *x509.Certificate) bool {
+	ids := tlsutil.Identities(cert)
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if len(t.ids) <= 1 {
+		return len(ids) > 0
+	}
+	for _, id := range ids {
+		if t.ids[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// Close implements the net.Listener interface.
+func (t *TLSStreamLayer) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.closed)
+	})
+	return t.listener.Close()
+}
+
+// Addr implements the net.Listener interface.
+func (t *TLSStreamLayer) Addr() net.Addr {
+	return t.advertise
+}